@@ -2,8 +2,9 @@ package main
 
 import (
 	"fmt"
-	"sync"
 	"time"
+
+	"github.com/jusongchen/goDemo/workers"
 )
 
 type Task int
@@ -15,29 +16,40 @@ type Result struct {
 }
 
 var (
-	semaphore chan struct{}
-	results   chan Result
+	results chan Result
 
 	//MDOP is the max degree of parallism, unexported
 	MDOP     = 3
 	numTasks = 3020
 )
 
+//implements workers.Task
+func (tsk Task) Exec(w workers.WorkerID) error {
+	res := Result{task: tsk, start: time.Now()}
+	//sleep for random period to simulate task processing
+	// d := time.Duration(rand.Int31n(10)) * time.Millisecond
+	time.Sleep(time.Second)
+	res.elapsed = time.Since(res.start)
+	results <- res
+	return nil
+}
+
 func main() {
 
-	semaphore = make(chan struct{}, MDOP)
-	tasks := make(chan Task)
 	results = make(chan Result)
 
-	go tuner()
-
 	//generate tasks
-	go func() {
-		for i := 0; i < numTasks; i++ {
-			tasks <- Task(i)
-		}
-		close(tasks)
-	}()
+	var i int
+	c := &workers.Context{
+		DOP: MDOP,
+		FactoryFunc: func() (workers.Task, error) {
+			if i == numTasks {
+				return nil, nil
+			}
+			i++
+			return Task(i - 1), nil
+		},
+	}
 
 	go func() {
 		for r := range results {
@@ -45,51 +57,18 @@ func main() {
 		}
 	}()
 
-	var wg sync.WaitGroup
-	wg.Add(numTasks)
+	h := workers.Start(c)
+	go tuner(h)
 
-	for tsk := range tasks {
-		semaphore <- struct{}{} //this will block when channel is full
-
-		go func(tsk Task) {
-			defer func() {
-				<-semaphore
-				wg.Done()
-			}()
-			res := Result{task: tsk, start: time.Now()}
-			//sleep for random period to simulate task processing
-			// d := time.Duration(rand.Int31n(10)) * time.Millisecond
-			time.Sleep(time.Second)
-			res.elapsed = time.Since(res.start)
-			results <- res
-		}(tsk)
-
-	}
-	wg.Wait()
+	h.Wait()
 	close(results)
-	close(semaphore)
 }
 
-func tuner() {
+func tuner(h *workers.Handle) {
 	c := time.Tick(time.Second * 5)
 	for now := range c {
 		AdjAmt := now.Second()/10 - 2
 		fmt.Printf("\nNow at AdjAmt %d\n==============================\n", AdjAmt)
-		adjust(AdjAmt)
-	}
-}
-
-func adjust(AdjAmt int) {
-	for {
-		switch {
-		case AdjAmt == 0:
-			return
-		case AdjAmt > 0:
-			<-semaphore
-			AdjAmt--
-		case AdjAmt < 0:
-			semaphore <- struct{}{}
-			AdjAmt++
-		}
+		h.Resize(h.DOP() + AdjAmt)
 	}
 }
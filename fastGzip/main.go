@@ -6,19 +6,47 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"net/http"
 	"os"
 	"path/filepath"
 	"regexp"
 	"runtime"
+	"sort"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/jusongchen/goDemo/workers"
 	"github.com/pkg/errors"
+	"golang.org/x/net/context"
 )
 
+//streamMem is about the memory a file takes while streamed through the stages whatever its size:
+//the state of its gzip writer and a copy buffer per stage
+const streamMem = 1 << 20
+
+//gzipCtx is a file passed through read, gzip and write stages, streamed from one stage to the next
 type gzipCtx struct {
-	source string
-	target string
+	source  string
+	target  string
+	size    int64
+	modTime time.Time
+	blocks  bool           //gzipped in blocks compressed in parallel, see compressBlocks
+	data    *io.PipeReader //content of source as read
+	gz      *io.PipeReader //content of target as gzipped
+	partial bool           //target created but not completely written
+	written chan struct{}  //closed once the write stage is done with the file, failed or not
+	once    sync.Once
+}
+
+func newGzipCtx(source string) *gzipCtx {
+	return &gzipCtx{source: source, target: source + ".gz", written: make(chan struct{})}
+}
+
+//streamed is the error of a stage once part of a file went down a pipe, trying the stage again would lose that part.
+//It has no Cause, so transient does not look into it.
+type streamed struct {
+	error
 }
 
 //startTimer return a function which calculates elapsed time when called.
@@ -31,44 +59,216 @@ func startTimer(name string) func() {
 	}
 }
 
-//implements workers.Task
-func (gz *gzipCtx) Exec(w workers.WorkerID) error {
-	stop := startTimer(fmt.Sprintf("worker #%d %s", w, gz.source))
-	defer stop()
+//produce writes to w what f writes, then closes w with the error of f. The reader of w was handed to
+//the next stage, which may never take it, so w fails once ctx is done rather than block.
+func produce(ctx context.Context, w *io.PipeWriter, f func(io.Writer) error) {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			w.CloseWithError(ctx.Err())
+		case <-done:
+		}
+	}()
+	w.CloseWithError(f(w))
+}
 
+//read streams the source file to the gzip stage, the file is read as it is gzipped.
+//Once emitted, errors go down the pipe and fail the file in the write stage.
+//The Budget of the read stage bounds the whole pipeline: the worker holds the Cost of the file
+//until it is written, so files queued between stages or being written count as well.
+func read(ctx context.Context, item interface{}, emit func(interface{}) error) error {
+	gz := item.(*gzipCtx)
 	reader, err := os.Open(gz.source)
 	if err != nil {
 		return err
 	}
+	defer reader.Close()
 
-	filename := filepath.Base(gz.source)
-	writer, err := os.Create(gz.target)
-	if err != nil {
-		return errors.Wrap(err, "gzip exec")
+	var w *io.PipeWriter
+	gz.data, w = io.Pipe()
+	if err := emit(gz); err != nil {
+		return err
+	}
+	produce(ctx, w, func(w io.Writer) error {
+		_, err := io.Copy(w, workers.NewReader(ctx, reader))
+		return errors.Wrap(err, "gzip read")
+	})
+	select {
+	case <-gz.written:
+	case <-ctx.Done():
 	}
+	return nil
+}
 
-	defer writer.Close()
+//compress streams the content of a file read to the write stage, gzipped at once or in blocks
+func compress(ctx context.Context, item interface{}, emit func(interface{}) error) error {
+	gz := item.(*gzipCtx)
+	if gz.blocks {
+		return compressLarge(ctx, gz, emit)
+	}
+	stop := startTimer(fmt.Sprintf("gzip %s", gz.source))
+	defer stop()
 
-	archiver := gzip.NewWriter(writer)
-	archiver.Name = filename
-	defer archiver.Close()
+	var w *io.PipeWriter
+	gz.gz, w = io.Pipe()
+	if err := emit(gz); err != nil {
+		return err
+	}
+	produce(ctx, w, func(w io.Writer) error {
+		archiver := gzip.NewWriter(w)
+		archiver.Name = filepath.Base(gz.source)
+		if _, err := io.Copy(archiver, gz.data); err != nil {
+			return err
+		}
+		return archiver.Close()
+	})
+	gz.data.Close() //stops the read stage if the write stage failed first
+	return nil
+}
+
+//compressLarge streams a large file to the write stage gzipped in blocks, deflated in parallel by DOP workers of its own
+func compressLarge(ctx context.Context, gz *gzipCtx, emit func(interface{}) error) error {
+	stop := startTimer(fmt.Sprintf("gzip %s in blocks", gz.source))
+	defer stop()
 
-	_, err = io.Copy(archiver, reader)
-	return err
+	var w *io.PipeWriter
+	gz.gz, w = io.Pipe()
+	if err := emit(gz); err != nil {
+		return err
+	}
+	produce(ctx, w, func(w io.Writer) error {
+		return compressBlocks(ctx, w, gz.data, filepath.Base(gz.source), gz.modTime, gzip.DefaultCompression, blockKB<<10, DOP)
+	})
+	gz.data.Close()
+	return nil
 }
 
-//taskFunc return a function which makes tasks
-func taskFunc(srcFiles []string) workers.FactoryFunc {
+//write returns a stage function saving gzipped content to the target file and recording it in journal, if any
+func write(journal *workers.Journal) workers.StageFunc {
+	return func(ctx context.Context, item interface{}, emit func(interface{}) error) error {
+		gz := item.(*gzipCtx)
+		writer, err := os.Create(gz.target)
+		if err != nil {
+			return errors.Wrap(err, "gzip write")
+		}
+		gz.partial = true
+		_, err = io.Copy(writer, workers.NewReader(ctx, gz.gz))
+		if err == nil {
+			err = writer.Sync() //the journal must not record a target a crash could still truncate
+		}
+		if cerr := writer.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return streamed{errors.Wrap(err, "gzip write")}
+		}
+		gz.partial = false
+		if journal != nil {
+			if err := journal.Record(gz.source); err != nil {
+				return err
+			}
+		}
+		gz.done()
+		return emit(gz)
+	}
+}
 
-	var index int
-	return func() workers.Task {
-		if index == len(srcFiles) { //
-			return nil
+//implements workers.Cleaner, stages streaming the file stop and a half-written target is removed
+func (gz *gzipCtx) Cleanup(err error) {
+	for _, r := range []*io.PipeReader{gz.data, gz.gz} {
+		if r != nil {
+			r.CloseWithError(err)
 		}
-		name := srcFiles[index]
-		index++
-		return &gzipCtx{source: name, target: name + ".gz"}
 	}
+	if gz.partial {
+		os.Remove(gz.target)
+		gz.partial = false
+	}
+	gz.done()
+}
+
+//done tells the read stage the file is out of the pipeline, its memory is free
+func (gz *gzipCtx) done() {
+	gz.once.Do(func() { close(gz.written) })
+}
+
+//implements workers.Coster, a file takes streamMem from being read until it is written.
+//One gzipped in blocks takes about 2*DOP blocks and their output, and DOP deflate writers.
+func (gz *gzipCtx) Cost() workers.Resources {
+	if gz.blocks {
+		return workers.Resources{Memory: int64(DOP) * (4*int64(blockKB)<<10 + streamMem)}
+	}
+	return workers.Resources{Memory: streamMem}
+}
+
+//implements fmt.Stringer
+func (gz *gzipCtx) String() string {
+	return gz.source
+}
+
+//transient tells if a gzip error may go away when tried again
+func transient(err error) bool {
+	switch errors.Cause(err) {
+	case syscall.EBUSY, syscall.EAGAIN, syscall.EINTR:
+		return true
+	}
+	if pe, ok := errors.Cause(err).(*os.PathError); ok {
+		return transient(pe.Err)
+	}
+	return false
+}
+
+//source emits a file for each source file, larger files first so the longest gzip does not start last.
+//Files of at least split bytes are gzipped in blocks, split <= 0 means none is.
+func source(srcFiles []string, split int64) func(ctx context.Context, emit func(interface{}) error) error {
+	return func(ctx context.Context, emit func(interface{}) error) error {
+		files := []*gzipCtx{}
+		for _, name := range srcFiles {
+			gz := newGzipCtx(name)
+			if info, err := os.Stat(name); err == nil {
+				gz.size, gz.modTime = info.Size(), info.ModTime()
+				gz.blocks = split > 0 && gz.size >= split
+			}
+			files = append(files, gz)
+		}
+		sort.SliceStable(files, func(i, j int) bool { return files[i].size > files[j].size })
+		for _, gz := range files {
+			if err := emit(gz); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+//serveMetrics exports metrics of each stage over HTTP at addr
+func serveMetrics(addr string, stages []*workers.Stage) {
+	for _, s := range stages {
+		s.Workers.Metrics = &workers.Metrics{Namespace: "fastgzip_" + s.Name}
+		s.Workers.Metrics.Publish("fastgzip_" + s.Name)
+	}
+	http.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		for _, s := range stages {
+			s.Workers.Metrics.WritePrometheus(w)
+		}
+	})
+	go func() {
+		log.Println(http.ListenAndServe(addr, nil))
+	}()
+}
+
+//pending returns files not gzipped yet according to journal
+func pending(files []string, journal *workers.Journal) []string {
+	left := []string{}
+	for _, name := range files {
+		if !journal.Done(name) {
+			left = append(left, name)
+		}
+	}
+	return left
 }
 
 //FindFiles search directory tree to get files matching regexp pattern
@@ -101,10 +301,32 @@ func FindFiles(root string, pattern string) ([]string, error) {
 }
 
 //DOP degree of parallelism
-var DOP int
+var (
+	DOP         int
+	maxFailures int
+	maxAttempts int
+	maxDOP      int
+	memMB       int64
+	splitMB     int64
+	blockKB     int
+	journalFile string
+	drainTime   time.Duration
+	progress    time.Duration
+	metricsAddr string
+)
 
 func main() {
 	flag.IntVar(&DOP, "DOP", runtime.NumCPU(), "Degree of Parallelism, must be >= 1")
+	flag.IntVar(&maxDOP, "maxDOP", 0, "tune the number of files gzipped at once between 1 and maxDOP while running, 0 means fixed DOP")
+	flag.Int64Var(&memMB, "mem", 0, "MiB taken by files in the pipeline at once, from being read until written, 1 MiB each; 0 means no limit")
+	flag.DurationVar(&progress, "progress", 5*time.Second, "interval to report progress, 0 means no progress report")
+	flag.StringVar(&metricsAddr, "metrics", "", "address serving Prometheus /metrics and expvar /debug/vars, empty means no metrics")
+	flag.DurationVar(&drainTime, "drain", 30*time.Second, "time files being gzipped may take to finish after an interrupt, 0 means no limit")
+	flag.Int64Var(&splitMB, "split", 0, "MiB from which a file is gzipped in blocks compressed in parallel by DOP workers of its own; 0 means never")
+	flag.IntVar(&blockKB, "block", 128, "KiB of a block of a file gzipped in blocks, at least 32")
+	flag.StringVar(&journalFile, "journal", "", "file recording files gzipped, a rerun with the same journal skips them")
+	flag.IntVar(&maxFailures, "maxFail", 0, "stop after N files failed to gzip, 0 means gzip as many files as possible")
+	flag.IntVar(&maxAttempts, "retry", 3, "times to try gzipping a file which failed with a transient error")
 
 	flag.Usage = func() {
 		fmt.Printf("%s by Jusong Chen\n", os.Args[0])
@@ -117,7 +339,7 @@ func main() {
 
 	flag.Parse()
 
-	if flag.NArg() != 2 || DOP < 1 {
+	if flag.NArg() != 2 || DOP < 1 || maxDOP < 0 || maxFailures < 0 || memMB < 0 || splitMB < 0 || blockKB < 32 {
 		flag.Usage()
 	}
 	path, err := filepath.Abs(flag.Arg(0))
@@ -126,21 +348,91 @@ func main() {
 	}
 	pattern := flag.Arg(1)
 
+	if err := run(path, pattern); err != nil {
+		log.Fatal(err)
+	}
+}
+
+//run gzips files under path matching pattern, deferred calls such as closing the journal
+//are done by the time it returns, log.Fatal in main would skip them
+func run(path, pattern string) error {
 	files, err := FindFiles(path, pattern)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
-	c := &workers.Context{
-		DOP:         DOP,
-		FactoryFunc: taskFunc(files),
+	var journal *workers.Journal
+	if journalFile != "" {
+		if journal, err = workers.OpenJournal(journalFile); err != nil {
+			return err
+		}
+		defer journal.Close()
+		all := len(files)
+		files = pending(files, journal)
+		log.Printf("%d files gzipped by an earlier run skipped", all-len(files))
+	}
+
+	retry := &workers.RetryPolicy{
+		MaxAttempts: maxAttempts,
+		Backoff:     100 * time.Millisecond,
+		MaxBackoff:  5 * time.Second,
+		Jitter:      0.2,
+		Retryable:   transient,
+	}
+	//each stage has its own workers, a file holds a worker of each stage from being read until written:
+	//read admits files, gzip and write have a worker for as many files as read may admit
+	inFlight := DOP
+	if maxDOP > DOP {
+		inFlight = maxDOP
+	}
+	stages := []*workers.Stage{
+		{Name: "read", Func: read, Workers: workers.Context{DOP: DOP, Retry: retry}},
+		{Name: "gzip", Func: compress, Workers: workers.Context{DOP: inFlight}},
+		{Name: "write", Func: write(journal), Workers: workers.Context{DOP: inFlight, Retry: retry}},
+	}
+	for _, s := range stages {
+		s.Workers.ErrorPolicy = workers.ContinueOnError
+		if maxFailures > 0 {
+			s.Workers.ErrorPolicy = workers.StopAfterFailures
+			s.Workers.MaxFailures = maxFailures
+		}
+	}
+	if memMB > 0 { //read holds the memory of a file until the write stage is done with it
+		stages[0].Workers.Budget.Memory = memMB << 20
+	}
+	if metricsAddr != "" {
+		serveMetrics(metricsAddr, stages)
+	}
+	if maxDOP > 0 { //tuning read sets how many files are gzipped at once
+		stages[0].Workers.Tuner = &workers.Tuner{Min: 1, Max: maxDOP}
+	}
+	if progress > 0 {
+		stages[2].Workers.Progress = &workers.Progress{Total: len(files), Interval: progress}
 	}
 
 	stop := startTimer(fmt.Sprintf("gzip %d files", len(files)))
 	defer stop()
-	err = workers.Do(c)
+	//the first interrupt stops gzipping more files, the second one aborts files being gzipped
+	drain, ctx, stopSignals := workers.NotifyShutdown(context.Background(), drainTime)
+	defer stopSignals()
+	//files of at least -split MiB go through the stages as the others do, only gzipped in blocks
+	p := &workers.Pipeline{Context: ctx, Drain: drain, Source: source(files, splitMB<<20), Stages: stages}
+	reports, err := p.Run()
+	for _, report := range reports {
+		for _, res := range report.Failed() {
+			if pe, ok := res.Err.(*workers.PanicError); ok {
+				log.Printf("%v\n%s", pe, pe.Stack)
+			}
+		}
+		for _, res := range report.Results {
+			if res.Attempts > 1 {
+				log.Printf("%v done after %d attempts", res.Task, res.Attempts)
+			}
+		}
+	}
 	if err != nil {
-		log.Fatal(err)
+		written := reports[len(reports)-1]
+		return errors.Errorf("%d of %d files gzipped, %v", len(written.Results)-len(written.Failed()), len(files), err)
 	}
-
+	return nil
 }
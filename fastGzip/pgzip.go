@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"time"
+
+	"github.com/jusongchen/goDemo/workers"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+//dictSize is how much of the previous block primes the compression of a block, the deflate window
+const dictSize = 32 << 10
+
+//block is a slice of a file deflated on its own, primed with the end of the slice before it
+type block struct {
+	dict  []byte
+	data  []byte
+	last  bool
+	level int
+	out   bytes.Buffer
+}
+
+//implements workers.Task, a block ends on a byte boundary so blocks deflated apart concatenate into one stream
+func (b *block) Exec(w workers.WorkerID) error {
+	fw, err := flate.NewWriterDict(&b.out, b.level, b.dict)
+	if err != nil {
+		return err
+	}
+	if _, err := fw.Write(b.data); err != nil {
+		return err
+	}
+	if b.last {
+		return fw.Close()
+	}
+	return fw.Flush()
+}
+
+//gzipHeader returns a gzip member header naming the file compressed, RFC 1952
+func gzipHeader(name string, modTime time.Time, level int) []byte {
+	h := []byte{0x1f, 0x8b, 8, 0, 0, 0, 0, 0, 0, 255} //magic, deflate, no flags, no mtime, no xfl, unknown OS
+	if name != "" {
+		h[3] |= 0x08 //FNAME
+	}
+	if !modTime.IsZero() && modTime.Unix() > 0 {
+		binary.LittleEndian.PutUint32(h[4:8], uint32(modTime.Unix()))
+	}
+	switch level {
+	case flate.BestCompression:
+		h[8] = 2
+	case flate.BestSpeed:
+		h[8] = 4
+	}
+	if name != "" {
+		h = append(append(h, name...), 0)
+	}
+	return h
+}
+
+//compressBlocks writes to dst a gzip stream of src, as pigz does: src is split into blocks of blockSize
+//deflated in parallel by DOP workers, each primed with the end of the block before it so the stream
+//compresses about as well as a serial one. About 2*DOP blocks are held in memory.
+func compressBlocks(ctx context.Context, dst io.Writer, src io.Reader, name string, modTime time.Time, level, blockSize, DOP int) error {
+	if blockSize < dictSize {
+		blockSize = dictSize
+	}
+	if _, err := dst.Write(gzipHeader(name, modTime, level)); err != nil {
+		return err
+	}
+
+	pool := workers.NewPool(&workers.Context{Context: ctx, DOP: DOP}, DOP)
+	defer pool.Close()
+	var window []*workers.Future //blocks submitted, in order
+	flush := func(keep int) error {
+		for len(window) > keep {
+			res := window[0].Result()
+			window = window[1:]
+			if res.Err != nil {
+				return res.Err
+			}
+			if _, err := dst.Write(res.Task.(*block).out.Bytes()); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	crc := crc32.NewIEEE()
+	var size uint32 //modulo 2^32 as ISIZE
+	var dict []byte
+	next, err := readBlock(src, blockSize)
+	for {
+		if err != nil {
+			return errors.Wrap(err, "gzip read")
+		}
+		data := next
+		next, err = readBlock(src, blockSize) //one block ahead to know which one is last
+		b := &block{dict: dict, data: data, last: len(next) == 0 && err == nil, level: level}
+		crc.Write(data)
+		size += uint32(len(data))
+		f, serr := pool.Submit(ctx, b)
+		if serr != nil {
+			return serr
+		}
+		window = append(window, f)
+		if ferr := flush(DOP); ferr != nil {
+			return ferr
+		}
+		if b.last {
+			break
+		}
+		if len(data) > dictSize {
+			dict = data[len(data)-dictSize:]
+		} else {
+			dict = data
+		}
+	}
+	if err := flush(0); err != nil {
+		return err
+	}
+
+	trailer := make([]byte, 8)
+	binary.LittleEndian.PutUint32(trailer[:4], crc.Sum32())
+	binary.LittleEndian.PutUint32(trailer[4:], size)
+	if _, err := dst.Write(trailer); err != nil {
+		return err
+	}
+	return pool.Close()
+}
+
+//readBlock reads up to n bytes of r, an empty block means r is at EOF
+func readBlock(r io.Reader, n int) ([]byte, error) {
+	data := make([]byte, n)
+	read, err := io.ReadFull(r, data)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	return data[:read], err
+}
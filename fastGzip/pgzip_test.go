@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"math/rand"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+//testData returns n bytes of text, compressible as real files are
+func testData(n int) []byte {
+	words := strings.Fields("the quick brown fox jumps over the lazy dog while workers gzip blocks in parallel")
+	r := rand.New(rand.NewSource(int64(n)))
+	var b bytes.Buffer
+	for b.Len() < n {
+		b.WriteString(words[r.Intn(len(words))])
+		b.WriteByte(" \n"[r.Intn(2)])
+	}
+	return b.Bytes()[:n]
+}
+
+//randomData returns n bytes which do not compress
+func randomData(n int) []byte {
+	data := make([]byte, n)
+	rand.New(rand.NewSource(int64(n))).Read(data)
+	return data
+}
+
+//TestCompressBlocks test a stream compressed in blocks decompresses to its input
+func TestCompressBlocks(t *testing.T) {
+	const blockSize = dictSize
+	modTime := time.Unix(1500000000, 0)
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"one byte", []byte("x")},
+		{"less than a block", testData(blockSize - 1)},
+		{"a block", testData(blockSize)},
+		{"blocks", testData(3*blockSize + 7)},
+		{"many blocks", testData(40 * blockSize)},
+		{"random", randomData(5*blockSize + 1)},
+	}
+	for _, tt := range tests {
+		for _, DOP := range []int{1, 4} {
+			var out bytes.Buffer
+			if err := compressBlocks(context.Background(), &out, bytes.NewReader(tt.data), "data.txt", modTime, gzip.DefaultCompression, blockSize, DOP); err != nil {
+				t.Fatalf("%s DOP %d: %v", tt.name, DOP, err)
+			}
+			r, err := gzip.NewReader(bytes.NewReader(out.Bytes()))
+			if err != nil {
+				t.Fatalf("%s DOP %d: %v", tt.name, DOP, err)
+			}
+			r.Multistream(false)
+			data, err := ioutil.ReadAll(r) //checks CRC and size in the trailer too
+			if err != nil {
+				t.Fatalf("%s DOP %d: %v", tt.name, DOP, err)
+			}
+			if !bytes.Equal(data, tt.data) {
+				t.Errorf("%s DOP %d: expected %d bytes back, actual %d differing", tt.name, DOP, len(tt.data), len(data))
+			}
+			if r.Name != "data.txt" || !r.ModTime.Equal(modTime) {
+				t.Errorf("%s DOP %d: expected header of data.txt at %v, actual %q at %v", tt.name, DOP, modTime, r.Name, r.ModTime)
+			}
+		}
+	}
+}
+
+//TestCompressBlocksRatio test blocks primed with the previous block compress about as well as gzip
+func TestCompressBlocksRatio(t *testing.T) {
+	data := testData(1 << 20)
+	var serial bytes.Buffer
+	w := gzip.NewWriter(&serial)
+	w.Write(data)
+	w.Close()
+
+	var parallel bytes.Buffer
+	if err := compressBlocks(context.Background(), &parallel, bytes.NewReader(data), "", time.Time{}, gzip.DefaultCompression, dictSize, 4); err != nil {
+		t.Fatal(err)
+	}
+	if float64(parallel.Len()) > 1.02*float64(serial.Len()) {
+		t.Errorf("expected about %d bytes as gzip, actual %d", serial.Len(), parallel.Len())
+	}
+}
+
+//TestCompressBlocksGunzip test gunzip reads a stream compressed in blocks
+func TestCompressBlocksGunzip(t *testing.T) {
+	gunzip, err := exec.LookPath("gunzip")
+	if err != nil {
+		t.Skip("no gunzip")
+	}
+	data := testData(10*dictSize + 3)
+	var out bytes.Buffer
+	if err := compressBlocks(context.Background(), &out, bytes.NewReader(data), "data.txt", time.Now(), gzip.BestSpeed, dictSize, 4); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(gunzip, "-c")
+	cmd.Stdin = &out
+	back, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(back, data) {
+		t.Errorf("expected %d bytes from gunzip, actual %d differing", len(data), len(back))
+	}
+}
+
+//TestCompressBlocksCancel test compression stops once ctx is done
+func TestCompressBlocksCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := compressBlocks(ctx, ioutil.Discard, bytes.NewReader(testData(100*dictSize)), "", time.Time{}, gzip.DefaultCompression, dictSize, 2)
+	if err == nil {
+		t.Error("expected an error once cancelled")
+	}
+}
@@ -13,14 +13,9 @@ import (
 
 	"github.com/jusongchen/goDemo/workers"
 	"github.com/pkg/errors"
+	"golang.org/x/net/context"
 )
 
-type wordCnt struct {
-	source     string
-	re         *regexp.Regexp
-	numMatches int64
-}
-
 //startTimer return a function which calculates elapsed time when called.
 func startTimer(name string) func() {
 	t := time.Now()
@@ -31,86 +26,78 @@ func startTimer(name string) func() {
 	}
 }
 
-//implements workers.Task
-func (cnt *wordCnt) Exec(w workers.WorkerID) error {
-	// stop := startTimer(fmt.Sprintf("worker #%d %s", w, cnt.source))
-	defer func() {
-		// stop()
-		log.Printf("Worker #%d File %s pattern %s found:%d\n", w, cnt.source, cnt.re.String(), cnt.numMatches)
-	}()
-
-	f, err := os.Open(cnt.source)
-	defer f.Close()
-
-	if err != nil {
-		return err
-	}
-	r := bufio.NewReader(f)
-
-	for {
-		loc := cnt.re.FindReaderIndex(r)
-		if loc == nil {
-			break
+//wordCnt returns a function counting matches of re in a file, the scan stops once ctx is cancelled
+func wordCnt(re *regexp.Regexp) func(ctx context.Context, source string) (int64, error) {
+	return func(ctx context.Context, source string) (int64, error) {
+		f, err := os.Open(source)
+		if err != nil {
+			return 0, err
 		}
-		cnt.numMatches++
-	}
+		defer f.Close()
+		r := bufio.NewReader(workers.NewReader(ctx, f))
 
-	return err
-}
-
-//taskFunc return a function which makes tasks
-func taskFunc(srcFiles []string, re *regexp.Regexp) workers.FactoryFunc {
-
-	var index int
-	return func() workers.Task {
-		if index == len(srcFiles) { //
-			return nil
+		var numMatches int64
+		for re.FindReaderIndex(r) != nil {
+			numMatches++
 		}
-		name := srcFiles[index]
-		index++
-		return &wordCnt{source: name, re: re}
+		return numMatches, ctx.Err()
 	}
 }
 
-//FindFiles search directory tree to get files matching regexp pattern
-func FindFiles(root string, pattern string) ([]string, error) {
+//FindFiles search directory tree and calls found with each file matching regexp pattern
+func FindFiles(root string, pattern string, found func(path string) error) error {
 
-	m := []string{}
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return errors.Wrap(err, "filepath Walk")
+		}
 
-	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
 		if !info.Mode().IsRegular() {
 			return nil
 		}
 
-		if err != nil {
-			return errors.Wrap(err, "filepath Walk")
-		}
-
 		matched, err := regexp.MatchString(pattern, info.Name())
 		if err != nil {
 			return errors.Wrap(err, "FindFiles regexp")
 		}
-		// matched := true
 		if matched {
-			// fmt.Println("Find file:", path)
-			m = append(m, path)
+			return found(path)
 		}
 		return nil
 	})
-	// fmt.Printf("Files:%v", m)
-	return m, err
+}
+
+//writeTrace saves spans of scanned files to be viewed in chrome://tracing
+func writeTrace(name string, tracer *workers.ChromeTracer) {
+	f, err := os.Create(name)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	defer f.Close()
+	if _, err := tracer.WriteTo(f); err != nil {
+		log.Println(err)
+	}
 }
 
 //DOP degree of parallelism
 var (
 	DOP         int
+	maxDOP      int
+	progress    time.Duration
+	timeout     time.Duration
 	wordPattern string
+	traceFile   string
 	re          regexp.Regexp
 )
 
 func main() {
 	flag.IntVar(&DOP, "DOP", runtime.NumCPU(), "Degree of Parallelism, must be >= 1")
+	flag.IntVar(&maxDOP, "maxDOP", 0, "tune Degree of Parallelism between 1 and maxDOP while running, 0 means fixed DOP")
+	flag.DurationVar(&progress, "progress", 5*time.Second, "interval to report progress, 0 means no progress report")
+	flag.DurationVar(&timeout, "timeout", 0, "time limit to scan a file, files timed out are skipped, 0 means no limit")
 	flag.StringVar(&wordPattern, "e", "", "pattern, must have")
+	flag.StringVar(&traceFile, "trace", "", "file to write a Chrome trace of file scans to, empty means no trace")
 
 	flag.Usage = func() {
 		fmt.Printf("%s by Jusong Chen\n", os.Args[0])
@@ -123,7 +110,7 @@ func main() {
 
 	flag.Parse()
 
-	if flag.NArg() != 2 || DOP < 1 || wordPattern == "" {
+	if flag.NArg() != 2 || DOP < 1 || maxDOP < 0 || wordPattern == "" {
 		flag.Usage()
 	}
 
@@ -135,21 +122,78 @@ func main() {
 	}
 	pattern := flag.Arg(1)
 
-	files, err := FindFiles(path, pattern)
-	if err != nil {
+	if err := run(path, pattern, re); err != nil {
 		log.Fatal(err)
 	}
+}
+
+//run counts matches of re in files under path matching pattern, deferred calls such as writing the trace
+//are done by the time it returns, log.Fatal in main would skip them
+func run(path, pattern string, re *regexp.Regexp) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	//grep files while the directory walk is still in progress
+	files := make(chan string, DOP)
+	walked := make(chan error, 1)
+	go func() {
+		defer close(files)
+		walked <- FindFiles(path, pattern, func(name string) error {
+			select {
+			case files <- name:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+	}()
 
 	c := &workers.Context{
-		DOP:         DOP,
-		FactoryFunc: taskFunc(files, re),
+		DOP:     DOP,
+		Timeout: timeout,
+	}
+	if timeout > 0 {
+		c.ErrorPolicy = workers.ContinueOnError
 	}
 
-	stop := startTimer(fmt.Sprintf("grep %d files", len(files)))
+	if maxDOP > 0 {
+		c.Tuner = &workers.Tuner{Min: 1, Max: maxDOP}
+	}
+	if progress > 0 {
+		c.Progress = &workers.Progress{Interval: progress}
+	}
+	if traceFile != "" {
+		tracer := workers.NewChromeTracer()
+		c.Tracer = tracer
+		defer writeTrace(traceFile, tracer)
+	}
+
+	stop := startTimer(fmt.Sprintf("grep files under %s", path))
 	defer stop()
-	err = workers.Do(c)
+	counts, err := workers.MapChan(ctx, files, wordCnt(re), c)
+	if m, ok := err.(workers.MultiError); ok {
+		skipped := 0
+		for _, e := range m {
+			if _, ok := e.Err.(*workers.TimeoutError); ok {
+				log.Printf("File %v skipped: %v\n", e.Task, e.Err)
+				skipped++
+			}
+		}
+		if skipped == len(m) {
+			err = nil
+		}
+	}
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+	if err := <-walked; err != nil { //the walk is over once all files were scanned
+		return err
 	}
 
+	var total int64
+	for _, n := range counts {
+		total += n
+	}
+	log.Printf("pattern %s found:%d in %d files\n", re.String(), total, len(counts))
+	return nil
 }
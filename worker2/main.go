@@ -5,11 +5,16 @@ import (
 	"log"
 	"net/http"
 	"sync"
+
+	"github.com/jusongchen/goDemo/workers"
+	"golang.org/x/net/context"
 )
 
 const (
 	//DOP degree of Pararism
 	DOP = 32
+	//QPS is the number of requests started per second
+	QPS = 20
 )
 
 // A Result contains the title and URL of a search result.
@@ -17,6 +22,12 @@ type Result struct {
 	domain, Status, serverTime, server, poweredBy string
 }
 
+//fetch gets a web site
+type fetch struct {
+	url    string
+	result chan Result
+}
+
 func main() {
 
 	domains := []string{"360.com", "adobe.com", "alibaba.com", "aliexpress.com", "amazon.co.jp", "amazon.co.uk", "amazon.com", "amazon.de",
@@ -36,23 +47,33 @@ func main() {
 }
 
 func fetchWebSites(domains []string) error {
-	errChan := make(chan error, 1)
-	sem := make(chan int, DOP) // N=DOP jobs at once
-	// numTasks := len(domains)
 	result := make(chan Result, DOP)
 
 	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		handleResult(result)
+	}()
 
-	go handleResult(result)
-
-	for _, domain := range domains {
-		wg.Add(1)
-		go worker("http://"+domain, sem, &wg, errChan, result)
+	var index int
+	c := &workers.Context{
+		DOP: DOP,
+		FactoryFunc: func() (workers.Task, error) {
+			if index == len(domains) {
+				return nil, nil
+			}
+			index++
+			return &fetch{url: "http://" + domains[index-1], result: result}, nil
+		},
+		ErrorPolicy: workers.ContinueOnError,
+		RateLimit:   &workers.RateLimit{Rate: QPS},
 	}
 
+	err := workers.Do(c)
+	close(result)
 	wg.Wait()
-	close(errChan)
-	return <-errChan
+	return err
 }
 
 func handleResult(res chan Result) {
@@ -62,35 +83,33 @@ func handleResult(res chan Result) {
 	}
 }
 
-func worker(url string, sem chan int, wg *sync.WaitGroup, errChan chan error, res chan Result) {
-	defer func() {
-		<-sem
-		wg.Done()
-	}()
+//implements workers.Task
+func (f *fetch) Exec(w workers.WorkerID) error {
+	return f.ExecContext(context.Background(), w)
+}
 
-	sem <- 1
+//implements workers.ContextTask
+func (f *fetch) ExecContext(ctx context.Context, w workers.WorkerID) error {
+	req, err := http.NewRequest("GET", f.url, nil)
+	if err != nil {
+		return err
+	}
 
 	//do the work
-	resp, err := http.Get(url)
-	//error handling
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
 	if err != nil {
-		select {
-		case errChan <- err:
-			// we're the first worker to fail
-		default:
-			//some other failure has already happened, discard this err by not sending it to the errChan
-		}
-		return
+		return err
 	}
 
 	//process result
 	defer resp.Body.Close()
 
-	res <- Result{
-		domain:     url,
+	f.result <- Result{
+		domain:     f.url,
 		Status:     resp.Status,
 		poweredBy:  resp.Header.Get("X-Powered-By"),
 		server:     resp.Header.Get("Server"),
 		serverTime: resp.Header.Get("Date"),
 	}
+	return nil
 }
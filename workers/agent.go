@@ -0,0 +1,226 @@
+package workers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+type (
+	//Agent pulls tasks from a Coordinator and executes them with workers of its own
+	Agent struct {
+		//URL is where the Coordinator is served, such as http://host:port/tasks
+		URL string
+		//Decode makes the task described by a payload of Remote.Payload
+		Decode func(payload []byte) (Task, error)
+		//Name identifies the agent in errors, defaults to host:pid
+		Name string
+		//Client sends requests to the Coordinator, nil means http.DefaultClient
+		Client *http.Client
+		//Workers configures workers of the agent, its Context and FactoryFunc are set by Run
+		Workers Context
+		//Backoff spaces requests for a task while the Coordinator cannot be reached, its MaxAttempts
+		//and Retryable are not used: the agent tries until its ctx is done. nil means 100ms up to 5s.
+		Backoff *RetryPolicy
+	}
+
+	//agentTask executes a task leased from the Coordinator and reports its outcome
+	agentTask struct {
+		a     *Agent
+		grant grant
+		Task
+		held   context.Context //done once the task is reported or its lease is lost
+		cancel context.CancelFunc
+		lost   chan struct{} //closed once the Coordinator refused to renew the lease
+	}
+)
+
+//Run executes tasks leased from the Coordinator until the Coordinator run is done or ctx is done.
+//Tasks failing do not stop the agent, the Coordinator decides what a failure means.
+//An agent which cannot reach the Coordinator, e.g. while it restarts, tries again until ctx is done.
+func (a *Agent) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel() //stops renewing leases of tasks the run did not execute
+	c := a.Workers
+	c.Context = ctx
+	backoff := a.Backoff
+	if backoff == nil {
+		backoff = &RetryPolicy{Backoff: 100 * time.Millisecond, MaxBackoff: 5 * time.Second, Jitter: 0.2}
+	}
+	c.FactoryFunc = func() (Task, error) {
+		for failures := 0; ; {
+			g, ok, err := a.lease(ctx)
+			if err != nil {
+				failures++
+				if !backoff.wait(ctx, c.clock(), failures) {
+					return nil, nil
+				}
+				continue
+			}
+			failures = 0
+			if !ok {
+				return nil, nil
+			}
+			if g.ID == "" { //no task yet
+				continue
+			}
+			t, err := a.Decode(g.Payload)
+			if err != nil {
+				a.report(ctx, "done", leaseReport{ID: g.ID, outcome: outcome{Error: err.Error()}})
+				continue
+			}
+			at := &agentTask{a: a, grant: g, Task: t, lost: make(chan struct{})}
+			at.held, at.cancel = context.WithCancel(ctx)
+			go at.renew() //from now on, as the task may wait for a free worker
+			return at, nil
+		}
+	}
+	if c.ErrorPolicy == FailFast {
+		c.ErrorPolicy = ContinueOnError
+	}
+	_, err := DoReport(&c)
+	if _, failed := err.(MultiError); failed {
+		return nil
+	}
+	return err
+}
+
+func (a *Agent) name() string {
+	if a.Name != "" {
+		return a.Name
+	}
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s:%d", host, os.Getpid())
+}
+
+func (a *Agent) client() *http.Client {
+	if a.Client != nil {
+		return a.Client
+	}
+	return http.DefaultClient
+}
+
+func (a *Agent) post(ctx context.Context, op string, body interface{}) (*http.Response, error) {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(a.URL, "/")+"/"+op, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := a.client().Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "agent "+op)
+	}
+	return resp, nil
+}
+
+//lease asks the Coordinator for a task, ok is false once the Coordinator run is done.
+//A grant with no ID means no task was available in time.
+func (a *Agent) lease(ctx context.Context) (g grant, ok bool, err error) {
+	resp, err := a.post(ctx, "lease", struct{}{})
+	if err != nil {
+		if ctx.Err() != nil {
+			return g, false, nil
+		}
+		return g, false, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		err = json.NewDecoder(resp.Body).Decode(&g)
+		return g, err == nil, errors.Wrap(err, "agent lease")
+	case http.StatusNoContent:
+		return g, true, nil
+	case http.StatusGone:
+		return g, false, nil
+	}
+	return g, false, errors.Errorf("agent lease: %s", resp.Status)
+}
+
+//report sends a renew or done report, it returns false if the Coordinator no longer holds the lease
+func (a *Agent) report(ctx context.Context, op string, rep leaseReport) (bool, error) {
+	rep.Agent = a.name()
+	resp, err := a.post(ctx, op, rep)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusGone:
+		return false, nil
+	}
+	return false, errors.Errorf("agent %s: %s", op, resp.Status)
+}
+
+//String names the task in errors and traces
+func (t *agentTask) String() string {
+	return fmt.Sprint(t.Task)
+}
+
+//implements wrapper, so Keyer, Coster and the like of the task decoded apply
+func (t *agentTask) wrapped() Task {
+	return t.Task
+}
+
+//implements Task
+func (t *agentTask) Exec(w WorkerID) error {
+	return t.ExecContext(context.Background(), w)
+}
+
+//renew renews the lease of the task until it is reported or its lease is lost
+func (t *agentTask) renew() {
+	ticker := time.NewTicker(t.grant.Lease / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if held, err := t.a.report(t.held, "renew", leaseReport{ID: t.grant.ID}); err == nil && !held {
+				close(t.lost)
+				t.cancel()
+				return
+			}
+		case <-t.held.Done():
+			return
+		}
+	}
+}
+
+//implements ContextTask, the task is cancelled if its lease is lost
+func (t *agentTask) ExecContext(ctx context.Context, w WorkerID) error {
+	defer t.cancel()
+	tctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-t.lost:
+			cancel()
+		case <-tctx.Done():
+		}
+	}()
+
+	rep := leaseReport{ID: t.grant.ID}
+	err := safeExec(tctx, t.Task, w)
+	if err != nil {
+		rep.Error = err.Error()
+	} else if rt, ok := t.Task.(ResultTask); ok {
+		if rep.Output, err = json.Marshal(rt.Result()); err != nil {
+			rep.Error = err.Error()
+		}
+	}
+	if _, rerr := t.a.report(ctx, "done", rep); rerr != nil {
+		return rerr
+	}
+	return err
+}
@@ -0,0 +1,421 @@
+package workers
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+type (
+	//Clock tells the time and makes timers, Context.Clock replaces the system clock to test runs
+	Clock interface {
+		Now() time.Time
+		NewTimer(d time.Duration) Timer
+		NewTicker(d time.Duration) Ticker
+		//AfterFunc calls f in its own goroutine once d elapsed, the Timer returned only stops it
+		AfterFunc(d time.Duration, f func()) Timer
+		//Sleep blocks for d, it returns ctx.Err() if ctx is done first
+		Sleep(ctx context.Context, d time.Duration) error
+	}
+
+	//Timer sends the time on C once it expires, as time.Timer does
+	Timer interface {
+		C() <-chan time.Time
+		Stop() bool
+	}
+
+	//Ticker sends the time on C every period, as time.Ticker does
+	Ticker interface {
+		C() <-chan time.Time
+		Stop()
+	}
+
+	//systemClock is the Clock of the time package
+	systemClock struct{}
+
+	systemTimer struct {
+		t *time.Timer
+	}
+
+	systemTicker struct {
+		t *time.Ticker
+	}
+
+	//FakeClock is a Clock whose time only moves when told to. Step makes it a deterministic scheduler:
+	//timers fire one instant at a time in the order of their deadlines, then in the order they were made.
+	//Run steps it on its own each time the workers of runs using it, and the goroutines executing their tasks,
+	//all wait on it: tasks which wait with Sleep given their ctx, and act on its time with AfterFunc,
+	//are then all done with the current instant.
+	FakeClock struct {
+		mu      sync.Mutex
+		now     time.Time
+		timers  []*fakeTimer //waiting, in the order they fire
+		nextID  int
+		actors  map[*actor]bool
+		changed chan struct{} //signalled once an actor waits or exits, Run then looks whether to step
+	}
+
+	//actor is a goroutine of a run which a FakeClock waits for before moving: a worker,
+	//or a goroutine executing a task with a timeout. Its context carries it under actorKey.
+	actor struct {
+		c     *FakeClock
+		ready func() bool //of an actor parked, tells whether it may go on; nil while it runs
+	}
+
+	actorKey struct{}
+
+	fakeTicker struct {
+		*fakeTimer
+	}
+
+	fakeTimer struct {
+		c      *FakeClock
+		id     int
+		when   time.Time
+		period time.Duration //of a ticker
+		ch     chan time.Time
+		f      func()          //of AfterFunc, called by the goroutine moving the clock
+		sleep  context.Context //of a goroutine in Sleep, which is awake once sleep is done
+		fired  bool
+	}
+)
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) NewTimer(d time.Duration) Timer {
+	return systemTimer{time.NewTimer(d)}
+}
+
+func (systemClock) NewTicker(d time.Duration) Ticker {
+	return systemTicker{time.NewTicker(d)}
+}
+
+func (systemClock) AfterFunc(d time.Duration, f func()) Timer {
+	return systemTimer{time.AfterFunc(d, f)}
+}
+
+func (c systemClock) Sleep(ctx context.Context, d time.Duration) error {
+	return sleep(ctx, c, d)
+}
+
+func (t systemTimer) C() <-chan time.Time {
+	return t.t.C
+}
+
+func (t systemTimer) Stop() bool {
+	return t.t.Stop()
+}
+
+func (t systemTicker) C() <-chan time.Time {
+	return t.t.C
+}
+
+func (t systemTicker) Stop() {
+	t.t.Stop()
+}
+
+//sleep waits for a timer of c to expire or ctx to be done
+func sleep(ctx context.Context, c Clock, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	t := c.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C():
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+//clock returns c.Clock or the system clock
+func (c *Context) clock() Clock {
+	if c.Clock != nil {
+		return c.Clock
+	}
+	return systemClock{}
+}
+
+//NewFakeClock returns a FakeClock set to now
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+//Now returns the time of the clock
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+//NewTimer returns a Timer firing once the clock moved d ahead, d <= 0 fires at the next Step or Advance
+func (c *FakeClock) NewTimer(d time.Duration) Timer {
+	return c.add(d, 0, nil, nil)
+}
+
+//AfterFunc calls f once the clock moved d ahead. Unlike time.AfterFunc, f is called by Step or Advance
+//before they return, so that whatever f sets in motion is under way by then.
+func (c *FakeClock) AfterFunc(d time.Duration, f func()) Timer {
+	return c.add(d, 0, f, nil)
+}
+
+//NewTicker returns a Ticker firing every d of the clock, it panics if d <= 0 as time.NewTicker does
+func (c *FakeClock) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("workers: non-positive interval for NewTicker")
+	}
+	return fakeTicker{c.add(d, d, nil, nil)}
+}
+
+//Sleep blocks until the clock moved d ahead or ctx is done, the goroutine counts as a sleeper meanwhile
+func (c *FakeClock) Sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	t := c.add(d, 0, nil, ctx)
+	defer t.Stop()
+	defer park(ctx, func() bool { return t.fired || ctx.Err() != nil })()
+	select {
+	case <-t.ch:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (c *FakeClock) add(d, period time.Duration, f func(), sleep context.Context) *fakeTimer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.nextID++
+	t := &fakeTimer{c: c, id: c.nextID, when: c.now.Add(d), period: period, ch: make(chan time.Time, 1), f: f, sleep: sleep}
+	c.schedule(t)
+	return t
+}
+
+//schedule inserts t among timers waiting, c.mu is held
+func (c *FakeClock) schedule(t *fakeTimer) {
+	i := sort.Search(len(c.timers), func(i int) bool {
+		u := c.timers[i]
+		return u.when.After(t.when) || u.when.Equal(t.when) && u.id > t.id
+	})
+	c.timers = append(c.timers, nil)
+	copy(c.timers[i+1:], c.timers[i:])
+	c.timers[i] = t
+}
+
+//remove takes t out of timers waiting, it returns false if t was not waiting. c.mu is held.
+func (c *FakeClock) remove(t *fakeTimer) bool {
+	for i, u := range c.timers {
+		if u == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+//fire fires timers due by until in order, moving the clock to each deadline.
+//c.mu is held, it returns functions of AfterFunc timers to be called once c.mu is released.
+func (c *FakeClock) fire(until time.Time) []func() {
+	var fs []func()
+	for len(c.timers) > 0 && !c.timers[0].when.After(until) {
+		t := c.timers[0]
+		c.timers = c.timers[1:]
+		if t.when.After(c.now) {
+			c.now = t.when
+		}
+		t.fired = true
+		if t.f != nil {
+			fs = append(fs, t.f)
+			continue
+		}
+		select {
+		case t.ch <- c.now:
+		default: //a ticker not read since its last tick drops this one
+		}
+		if t.period > 0 {
+			t.when = t.when.Add(t.period)
+			c.schedule(t)
+		}
+	}
+	return fs
+}
+
+//dropAwake forgets timers of sleepers woken up by their context, c.mu is held
+func (c *FakeClock) dropAwake() {
+	timers := c.timers[:0]
+	for _, t := range c.timers {
+		if t.sleep == nil || t.sleep.Err() == nil {
+			timers = append(timers, t)
+		}
+	}
+	c.timers = timers
+}
+
+//Advance moves the clock d ahead, firing timers due meanwhile in order
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	until := c.now.Add(d)
+	fs := c.fire(until)
+	c.now = until
+	c.mu.Unlock()
+	for _, f := range fs {
+		f()
+	}
+}
+
+//Step moves the clock to the deadline of the next timer and fires timers due then,
+//it returns false if no timer is waiting
+func (c *FakeClock) Step() bool {
+	c.mu.Lock()
+	c.dropAwake()
+	if len(c.timers) == 0 {
+		c.mu.Unlock()
+		return false
+	}
+	fs := c.fire(c.timers[0].when)
+	c.mu.Unlock()
+	for _, f := range fs {
+		f()
+	}
+	return true
+}
+
+//Run steps the clock each time the actors of runs using it all wait on it, until done is closed.
+//Runs then take no time while their tasks see time pass as with the system clock, deterministically
+//as long as tasks wait on nothing else. Goroutines other than workers and tasks, such as those
+//of FactoryFunc, are not waited for. Run returns an error once actors all wait while no timer is left.
+func (c *FakeClock) Run(done <-chan struct{}) error {
+	c.mu.Lock()
+	changed := c.signal()
+	c.mu.Unlock()
+	for {
+		select {
+		case <-done:
+			return nil
+		case <-changed:
+		}
+		c.mu.Lock()
+		if !c.idle() {
+			c.mu.Unlock()
+			continue
+		}
+		c.dropAwake()
+		if len(c.timers) == 0 {
+			c.mu.Unlock()
+			return errors.New("workers: deadlock, every actor waits on a FakeClock without timers")
+		}
+		fs := c.fire(c.timers[0].when)
+		c.signal() //timers fired may wake no actor
+		c.mu.Unlock()
+		for _, f := range fs {
+			f()
+		}
+	}
+}
+
+//idle reports whether there are actors and all are parked, c.mu is held
+func (c *FakeClock) idle() bool {
+	for a := range c.actors {
+		if a.ready == nil || a.ready() {
+			return false
+		}
+	}
+	return len(c.actors) > 0
+}
+
+//signal tells Run to look whether actors are idle and returns the channel it listens to, c.mu is held
+func (c *FakeClock) signal() chan struct{} {
+	if c.changed == nil {
+		c.changed = make(chan struct{}, 1)
+	}
+	select {
+	case c.changed <- struct{}{}:
+	default:
+	}
+	return c.changed
+}
+
+//enter registers a goroutine about to be started as an actor if clock is a FakeClock. It returns
+//the context the goroutine must wait with and the function the goroutine calls once it exits.
+func enter(ctx context.Context, clock Clock) (context.Context, func()) {
+	c, ok := clock.(*FakeClock)
+	if !ok {
+		return ctx, func() {}
+	}
+	a := &actor{c: c}
+	c.mu.Lock()
+	if c.actors == nil {
+		c.actors = make(map[*actor]bool)
+	}
+	c.actors[a] = true
+	c.mu.Unlock()
+	return context.WithValue(ctx, actorKey{}, a), func() {
+		c.mu.Lock()
+		delete(c.actors, a)
+		c.signal()
+		c.mu.Unlock()
+	}
+}
+
+//park tells the clock of the actor of ctx, if any, the actor waits until ready reports true.
+//ready is called with the clock locked and must keep reporting true once it did, so that the actor
+//counts as running until it calls the function returned.
+func park(ctx context.Context, ready func() bool) func() {
+	a, ok := ctx.Value(actorKey{}).(*actor)
+	if !ok {
+		return func() {}
+	}
+	a.c.mu.Lock()
+	a.ready = ready
+	a.c.signal()
+	a.c.mu.Unlock()
+	return func() {
+		a.c.mu.Lock()
+		a.ready = nil
+		a.c.mu.Unlock()
+	}
+}
+
+//closed reports whether ch is closed, without waiting
+func closed(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+//Sleepers returns how many goroutines wait in Sleep for the clock to move
+func (c *FakeClock) Sleepers() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for _, t := range c.timers {
+		if t.sleep != nil && t.sleep.Err() == nil {
+			n++
+		}
+	}
+	return n
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.ch
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.c.mu.Lock()
+	defer t.c.mu.Unlock()
+	return t.c.remove(t)
+}
+
+func (t fakeTicker) Stop() {
+	t.fakeTimer.Stop()
+}
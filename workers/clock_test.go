@@ -0,0 +1,105 @@
+package workers
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+//TestFakeClock test timers fire in the order of their deadlines as the clock steps
+func TestFakeClock(t *testing.T) {
+	epoch := time.Unix(0, 0)
+	clock := NewFakeClock(epoch)
+	late := clock.NewTimer(3 * time.Second)
+	early := clock.NewTimer(time.Second)
+	stopped := clock.NewTimer(2 * time.Second)
+	ticker := clock.NewTicker(time.Second)
+	if !stopped.Stop() {
+		t.Error("expected Stop to stop a timer waiting")
+	}
+
+	if !clock.Step() || !clock.Now().Equal(epoch.Add(time.Second)) {
+		t.Fatalf("expected Step to move to 1s, actual %v", clock.Now().Sub(epoch))
+	}
+	if at := <-early.C(); !at.Equal(epoch.Add(time.Second)) {
+		t.Errorf("expected early to fire at 1s, actual %v", at.Sub(epoch))
+	}
+	<-ticker.C()
+
+	clock.Advance(2500 * time.Millisecond)
+	if !clock.Now().Equal(epoch.Add(3500 * time.Millisecond)) {
+		t.Errorf("expected Advance to move to 3.5s, actual %v", clock.Now().Sub(epoch))
+	}
+	if at := <-late.C(); !at.Equal(epoch.Add(3 * time.Second)) {
+		t.Errorf("expected late to fire at 3s, actual %v", at.Sub(epoch))
+	}
+	if at := <-ticker.C(); !at.Equal(epoch.Add(2 * time.Second)) {
+		t.Errorf("expected the tick at 3s dropped as the one at 2s was not received, actual %v", at.Sub(epoch))
+	}
+	ticker.Stop()
+	if clock.Step() {
+		t.Errorf("expected no timer left")
+	}
+	select {
+	case <-stopped.C():
+		t.Error("expected a stopped timer not to fire")
+	default:
+	}
+}
+
+//TestFakeClockSleep test sleepers wake up as the clock steps or their context is done
+func TestFakeClockSleep(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 2)
+	go func() { errc <- clock.Sleep(context.Background(), time.Second) }()
+	go func() { errc <- clock.Sleep(ctx, time.Hour) }()
+	for clock.Sleepers() != 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	cancel()
+	if clock.Sleepers() != 1 {
+		t.Errorf("expected a sleeper cancelled to be awake, actual %d sleepers", clock.Sleepers())
+	}
+	if err := <-errc; err != context.Canceled {
+		t.Errorf("expected %v, actual %v", context.Canceled, err)
+	}
+	clock.Step()
+	if err := <-errc; err != nil {
+		t.Errorf("expected the sleeper to wake up, actual %v", err)
+	}
+	if d := clock.Now().Sub(time.Unix(0, 0)); d != time.Second {
+		t.Errorf("expected Step to skip the cancelled sleeper, actual %v", d)
+	}
+}
+
+//TestSimulateCancel test a run cancelled at a given time of the clock stops the timers running then
+func TestSimulateCancel(t *testing.T) {
+	epoch := time.Unix(0, 0)
+	clock := NewFakeClock(epoch)
+	timers := createTimers(10, clock)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	clock.AfterFunc(2500*time.Millisecond, cancel)
+
+	c := &Context{Context: ctx, DOP: 2, FactoryFunc: factoryFuncNoErr(timers)}
+	report, err := simulate(t, clock, c)
+	if err != context.Canceled {
+		t.Fatalf("expected %v, actual %v", context.Canceled, err)
+	}
+	if d := clock.Now().Sub(epoch); d != 2500*time.Millisecond {
+		t.Errorf("expected the run cancelled at 2.5s, actual %v", d)
+	}
+	for i, tm := range timers {
+		if finished := !tm.end.IsZero(); finished != (i < 4) {
+			t.Errorf("expected timers 0-3 only to finish, timer %d finished %v", i, finished)
+		}
+	}
+	for _, res := range report.Results {
+		if res.Status == Succeeded && res.Duration != time.Second {
+			t.Errorf("expected a timer to take 1s of the clock, actual %v", res.Duration)
+		}
+	}
+}
@@ -0,0 +1,287 @@
+package workers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+type (
+	//Remote is implemented by tasks an Agent may execute on behalf of a Coordinator
+	Remote interface {
+		//Payload describes the task to the Decode function of agents, it is called for each attempt
+		Payload() ([]byte, error)
+		//Complete receives the output of the task, the JSON of Result() if the agent task is a ResultTask
+		Complete(output []byte) error
+	}
+
+	//Coordinator hands tasks of a run to agents pulling them over HTTP.
+	//An agent holds a lease on each task it executes and renews it while the task runs;
+	//a task whose lease expires, e.g. as its agent died, is handed to another agent.
+	Coordinator struct {
+		//Lease is how long an agent may hold a task without renewing it, defaults to 30s
+		Lease time.Duration
+		//Poll is how long a request for a task waits for one to be available, defaults to 1s
+		Poll time.Duration
+
+		once   sync.Once
+		offers chan *offer
+		over   chan struct{} //closed once the run is done, agents then exit
+
+		mu     sync.Mutex
+		leases map[string]*offer
+		nextID int
+	}
+
+	//offer is an attempt of a task waiting for an agent, then leased by it
+	offer struct {
+		id       string
+		payload  []byte
+		deadline time.Time //guarded by Coordinator.mu
+		result   chan outcome
+	}
+
+	//outcome is what an agent reports once a task is done
+	outcome struct {
+		Agent  string          `json:"agent"`
+		Error  string          `json:"error,omitempty"`
+		Output json.RawMessage `json:"output,omitempty"`
+	}
+
+	//grant is the message leasing a task to an agent
+	grant struct {
+		ID      string        `json:"id"`
+		Payload []byte        `json:"payload"`
+		Lease   time.Duration `json:"lease"`
+	}
+
+	//leaseReport is the message of an agent renewing a lease or reporting a task done
+	leaseReport struct {
+		ID string `json:"id"`
+		outcome
+	}
+
+	//remoteTask executes a Remote task through an agent
+	remoteTask struct {
+		Task
+		Remote
+		co *Coordinator
+	}
+
+	//RemoteError is the error a task returned on an agent
+	RemoteError struct {
+		Agent string
+		Err   string
+	}
+)
+
+//ErrLeaseExpired is the error of a task attempt whose agent did not renew its lease in time
+var ErrLeaseExpired = errors.New("lease expired")
+
+func (e *RemoteError) Error() string {
+	return fmt.Sprintf("agent %s: %s", e.Agent, e.Err)
+}
+
+func (co *Coordinator) init() {
+	co.once.Do(func() {
+		co.offers = make(chan *offer)
+		co.over = make(chan struct{})
+		co.leases = map[string]*offer{}
+	})
+}
+
+func (co *Coordinator) lease() time.Duration {
+	if co.Lease > 0 {
+		return co.Lease
+	}
+	return 30 * time.Second
+}
+
+func (co *Coordinator) poll() time.Duration {
+	if co.Poll > 0 {
+		return co.Poll
+	}
+	return time.Second
+}
+
+//Run executes tasks made by c.FactoryFunc as Do does, Remote tasks are executed by agents.
+//c.DOP caps tasks offered to or leased by agents at once. A task whose lease expired, e.g. as its
+//agent died, is attempted again by another agent as c.Retry allows, up to 3 attempts if c.Retry is nil;
+//c.Retry.MaxAttempts <= 1 means such a task fails with ErrLeaseExpired. A Coordinator serves a single run.
+//Optional interfaces of Remote tasks, such as ResultTask, Keyer, Coster or Cleaner, apply as for local tasks.
+func (co *Coordinator) Run(c *Context) (*Report, error) {
+	co.init()
+	defer close(co.over)
+
+	rc := *c
+	rc.FactoryFunc = func() (Task, error) {
+		t, err := c.FactoryFunc()
+		if r, ok := t.(Remote); ok && err == nil {
+			return &remoteTask{Task: t, Remote: r, co: co}, nil
+		}
+		return t, err
+	}
+	retry := RetryPolicy{MaxAttempts: 3, Retryable: func(error) bool { return false }}
+	if c.Retry != nil {
+		retry = *c.Retry
+	}
+	if retryable := retry.Retryable; retryable != nil {
+		retry.Retryable = func(err error) bool {
+			return errors.Cause(err) == ErrLeaseExpired || retryable(err)
+		}
+	}
+	rc.Retry = &retry
+
+	report, err := DoReport(&rc)
+	for i, res := range report.Results {
+		if rt, ok := res.Task.(*remoteTask); ok {
+			report.Results[i].Task = rt.Task
+		}
+	}
+	if m, ok := err.(MultiError); ok {
+		for _, e := range m {
+			if rt, ok := e.Task.(*remoteTask); ok {
+				e.Task = rt.Task
+			}
+		}
+	}
+	if e, ok := err.(*TaskError); ok {
+		if rt, ok := e.Task.(*remoteTask); ok {
+			e.Task = rt.Task
+		}
+	}
+	return report, err
+}
+
+//String names the task in errors and traces
+func (t *remoteTask) String() string {
+	return fmt.Sprint(t.Task)
+}
+
+//implements wrapper, so Keyer, Coster, ResultTask and the like of the task apply
+func (t *remoteTask) wrapped() Task {
+	return t.Task
+}
+
+//implements Task
+func (t *remoteTask) Exec(w WorkerID) error {
+	return t.ExecContext(context.Background(), w)
+}
+
+//implements ContextTask, it offers the task to agents and waits for the agent which took it
+func (t *remoteTask) ExecContext(ctx context.Context, w WorkerID) error {
+	co := t.co
+	payload, err := t.Payload()
+	if err != nil {
+		return errors.Wrap(err, "remote payload")
+	}
+	o := &offer{payload: payload, result: make(chan outcome, 1)}
+	co.mu.Lock()
+	co.nextID++
+	o.id = strconv.Itoa(co.nextID)
+	co.mu.Unlock()
+
+	select {
+	case co.offers <- o:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(co.lease())
+	defer timer.Stop()
+	for {
+		select {
+		case res := <-o.result:
+			return t.complete(res)
+		case <-timer.C:
+			co.mu.Lock()
+			left := time.Until(o.deadline)
+			if left > 0 { //renewed
+				co.mu.Unlock()
+				timer.Reset(left)
+				continue
+			}
+			delete(co.leases, o.id) //a late report of the agent is refused
+			co.mu.Unlock()
+			select {
+			case res := <-o.result: //reported just before expiring
+				return t.complete(res)
+			default:
+				return ErrLeaseExpired
+			}
+		case <-ctx.Done():
+			co.mu.Lock()
+			delete(co.leases, o.id)
+			co.mu.Unlock()
+			return ctx.Err()
+		}
+	}
+}
+
+//complete hands the outcome reported by the agent to the task
+func (t *remoteTask) complete(res outcome) error {
+	if res.Error != "" {
+		return &RemoteError{Agent: res.Agent, Err: res.Error}
+	}
+	return t.Complete(res.Output)
+}
+
+//ServeHTTP serves agents: POST lease takes a task, POST renew extends a lease, POST done reports a task
+func (co *Coordinator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	co.init()
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST only", http.StatusMethodNotAllowed)
+		return
+	}
+	switch path.Base(r.URL.Path) {
+	case "lease":
+		co.serveLease(w, r)
+	case "renew", "done":
+		var rep leaseReport
+		if err := json.NewDecoder(r.Body).Decode(&rep); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		co.mu.Lock()
+		o, ok := co.leases[rep.ID]
+		if ok && path.Base(r.URL.Path) == "renew" {
+			o.deadline = time.Now().Add(co.lease())
+		} else if ok {
+			delete(co.leases, rep.ID)
+			o.result <- rep.outcome
+		}
+		co.mu.Unlock()
+		if !ok {
+			http.Error(w, "no such lease", http.StatusGone)
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+//serveLease waits for a task to be offered and leases it to the agent
+func (co *Coordinator) serveLease(w http.ResponseWriter, r *http.Request) {
+	timer := time.NewTimer(co.poll())
+	defer timer.Stop()
+	select {
+	case o := <-co.offers:
+		co.mu.Lock()
+		o.deadline = time.Now().Add(co.lease())
+		co.leases[o.id] = o
+		co.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(grant{ID: o.id, Payload: o.payload, Lease: co.lease()})
+	case <-co.over:
+		w.WriteHeader(http.StatusGone)
+	case <-timer.C:
+		w.WriteHeader(http.StatusNoContent)
+	case <-r.Context().Done():
+	}
+}
@@ -0,0 +1,288 @@
+package workers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+type (
+	//remoteSquare is the coordinator side of a square executed by agents
+	remoteSquare struct {
+		n        int
+		sq       int
+		fail     bool
+		crash    bool //the first attempt crashes its agent
+		sleep    time.Duration
+		payloads int32
+		cleaned  error
+	}
+
+	//squareOrder is the payload of a remoteSquare
+	squareOrder struct {
+		N     int           `json:"n"`
+		Fail  bool          `json:"fail,omitempty"`
+		Crash bool          `json:"crash,omitempty"`
+		Sleep time.Duration `json:"sleep,omitempty"`
+	}
+
+	//agentSquare is the agent side of a remoteSquare
+	agentSquare struct {
+		squareOrder
+		sq int
+	}
+)
+
+func (s *remoteSquare) Exec(id WorkerID) error {
+	s.sq = s.n * s.n
+	return nil
+}
+
+func (s *remoteSquare) Result() interface{} {
+	return s.sq
+}
+
+func (s *remoteSquare) Payload() ([]byte, error) {
+	first := atomic.AddInt32(&s.payloads, 1) == 1
+	return json.Marshal(squareOrder{N: s.n, Fail: s.fail, Crash: s.crash && first, Sleep: s.sleep})
+}
+
+func (s *remoteSquare) Complete(output []byte) error {
+	return json.Unmarshal(output, &s.sq)
+}
+
+func (s *remoteSquare) Cleanup(err error) {
+	s.cleaned = err
+}
+
+func (s *agentSquare) ExecContext(ctx context.Context, id WorkerID) error {
+	if s.Crash {
+		os.Exit(3)
+	}
+	select {
+	case <-time.After(s.Sleep):
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if s.Fail {
+		return errTest
+	}
+	s.sq = s.N * s.N
+	return nil
+}
+
+func (s *agentSquare) Exec(id WorkerID) error {
+	return s.ExecContext(context.Background(), id)
+}
+
+func (s *agentSquare) Result() interface{} {
+	return s.sq
+}
+
+func decodeSquare(payload []byte) (Task, error) {
+	s := &agentSquare{}
+	return s, json.Unmarshal(payload, &s.squareOrder)
+}
+
+func remoteSquares(N int) []*remoteSquare {
+	squares := make([]*remoteSquare, N)
+	for i := range squares {
+		squares[i] = &remoteSquare{n: i}
+	}
+	return squares
+}
+
+func remoteTasks(squares []*remoteSquare) []Task {
+	tasks := make([]Task, len(squares))
+	for i, s := range squares {
+		tasks[i] = s
+	}
+	return tasks
+}
+
+//runAgents runs n agents of co in process until the run of co is done
+func runAgents(t *testing.T, url string, n int) *sync.WaitGroup {
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a := &Agent{URL: url, Decode: decodeSquare, Workers: Context{DOP: 2}}
+			if err := a.Run(context.Background()); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	return &wg
+}
+
+//TestCoordinator test tasks executed by agents report their results and errors to the coordinator
+func TestCoordinator(t *testing.T) {
+	co := &Coordinator{Poll: 50 * time.Millisecond}
+	srv := httptest.NewServer(co)
+	defer srv.Close()
+	agents := runAgents(t, srv.URL+"/tasks", 3)
+
+	squares := remoteSquares(30)
+	squares[7].fail = true
+	report, err := co.Run(&Context{DOP: 6, FactoryFunc: FromSlice(remoteTasks(squares)), ErrorPolicy: ContinueOnError})
+	agents.Wait()
+
+	var re *RemoteError
+	if !errors.As(err, &re) || re.Err != errTest.Error() {
+		t.Fatalf("expected %v from an agent, actual %v", errTest, err)
+	}
+	if failed := report.Failed(); len(failed) != 1 || failed[0].Task != squares[7] {
+		t.Errorf("expected square 7 failed, actual %v", failed)
+	}
+	if !errors.As(squares[7].cleaned, &re) {
+		t.Errorf("expected square 7 cleaned up after the error of its agent, actual %v", squares[7].cleaned)
+	}
+	for i, s := range squares {
+		if i == 7 {
+			continue
+		}
+		if s.sq != i*i || report.Results[i].Value != i*i {
+			t.Errorf("expected square of %d, actual %d and result value %v", i, s.sq, report.Results[i].Value)
+		}
+	}
+}
+
+//TestCoordinatorRenew test a task running longer than its lease keeps it while its agent renews it
+func TestCoordinatorRenew(t *testing.T) {
+	co := &Coordinator{Lease: 60 * time.Millisecond, Poll: 50 * time.Millisecond}
+	srv := httptest.NewServer(co)
+	defer srv.Close()
+	agents := runAgents(t, srv.URL, 2)
+
+	squares := remoteSquares(4)
+	for _, s := range squares {
+		s.sleep = 200 * time.Millisecond
+	}
+	report, err := co.Run(&Context{DOP: 4, FactoryFunc: FromSlice(remoteTasks(squares))})
+	agents.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, res := range report.Results {
+		if res.Attempts != 1 {
+			t.Errorf("expected %v done in 1 attempt, actual %d", res.Task, res.Attempts)
+		}
+	}
+}
+
+//TestCoordinatorExpired test a task whose agent stops renewing its lease fails with ErrLeaseExpired
+func TestCoordinatorExpired(t *testing.T) {
+	co := &Coordinator{Lease: 50 * time.Millisecond, Poll: 50 * time.Millisecond}
+	srv := httptest.NewServer(co)
+	defer srv.Close()
+
+	go func() { //an agent which never reports
+		a := &Agent{URL: srv.URL}
+		for {
+			if _, ok, _ := a.lease(context.Background()); !ok {
+				return
+			}
+		}
+	}()
+	_, err := co.Run(&Context{DOP: 1, FactoryFunc: FromSlice(remoteTasks(remoteSquares(1)))})
+	if errors.Cause(err) != ErrLeaseExpired {
+		t.Errorf("expected %v, actual %v", ErrLeaseExpired, err)
+	}
+}
+
+//TestAgentUnreachable test agents keep asking for tasks while the Coordinator cannot be reached
+func TestAgentUnreachable(t *testing.T) {
+	co := &Coordinator{Poll: 50 * time.Millisecond}
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch n := atomic.AddInt32(&requests, 1); {
+		case n <= 3:
+			panic(http.ErrAbortHandler) //the connection is dropped
+		case n == 4:
+			http.Error(w, "restarting", http.StatusServiceUnavailable)
+		default:
+			co.ServeHTTP(w, r)
+		}
+	}))
+	defer srv.Close()
+	done := make(chan error, 1)
+	go func() {
+		a := &Agent{URL: srv.URL, Decode: decodeSquare, Backoff: &RetryPolicy{Backoff: time.Millisecond}}
+		done <- a.Run(context.Background())
+	}()
+
+	squares := remoteSquares(3)
+	if _, err := co.Run(&Context{DOP: 2, FactoryFunc: FromSlice(remoteTasks(squares))}); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("expected the agent to exit once the run is done, actual %v", err)
+	}
+	for i, s := range squares {
+		if s.sq != i*i {
+			t.Errorf("expected square of %d, actual %d", i, s.sq)
+		}
+	}
+}
+
+//TestDistributed test tasks are executed by agent processes and reassigned once an agent crashed
+func TestDistributed(t *testing.T) {
+	if url := os.Getenv("WORKERS_AGENT"); url != "" {
+		a := &Agent{URL: url, Decode: decodeSquare, Workers: Context{DOP: 2}}
+		if err := a.Run(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	co := &Coordinator{Lease: 300 * time.Millisecond, Poll: 100 * time.Millisecond}
+	srv := httptest.NewServer(co)
+	defer srv.Close()
+	cmds := make([]*exec.Cmd, 3)
+	for i := range cmds {
+		cmds[i] = exec.Command(os.Args[0], "-test.run", "^TestDistributed$")
+		cmds[i].Env = append(os.Environ(), "WORKERS_AGENT="+srv.URL)
+		if err := cmds[i].Start(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	squares := remoteSquares(40)
+	squares[5].crash = true
+	report, err := co.Run(&Context{DOP: 6, FactoryFunc: FromSlice(remoteTasks(squares))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	crashed := 0
+	for _, cmd := range cmds {
+		err := cmd.Wait()
+		if e, ok := err.(*exec.ExitError); ok && e.ExitCode() == 3 {
+			crashed++
+		} else if err != nil {
+			t.Errorf("expected agent to exit once the run is done, actual %v", err)
+		}
+	}
+	if crashed != 1 {
+		t.Errorf("expected 1 agent crashed, actual %d", crashed)
+	}
+	for i, s := range squares {
+		if s.sq != i*i {
+			t.Errorf("expected square of %d, actual %d", i, s.sq)
+		}
+	}
+	for _, res := range report.Results {
+		if res.Task == squares[5] && res.Attempts < 2 {
+			t.Errorf("expected square 5 attempted again, actual %d attempts", res.Attempts)
+		}
+	}
+}
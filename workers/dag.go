@@ -0,0 +1,272 @@
+package workers
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+type (
+	//Dependent is implemented by tasks which may only start once the tasks they depend on succeeded
+	Dependent interface {
+		Keyer
+		//DependsOn returns keys of the tasks depended on
+		DependsOn() []string
+	}
+
+	//CycleError is returned by DoGraph when tasks depend on each other in a cycle
+	CycleError struct {
+		Keys []string //the cycle, from a task to the one it depends on and so on back to the first task
+	}
+
+	//DependencyError is the error of a task blocked as a task it depends on did not succeed
+	DependencyError struct {
+		Key string //of the task depended on which failed, directly or not
+		Err error  //of the task which failed
+	}
+
+	//graph hands out tasks of DoGraph as they get ready
+	graph struct {
+		tasks  []Task
+		seqs   map[Task]int //index in tasks, the order FactoryFunc made them
+		keys   map[string]int
+		deps   [][]int //indexes of the tasks each task depends on
+		users  [][]int //indexes of the tasks depending on each task
+		ready  chan Task
+		ctx    context.Context //done once the run stopped, it unblocks the generator
+		cancel context.CancelFunc
+
+		mu      sync.Mutex
+		waiting []int //number of dependencies not yet done
+		left    int   //tasks not yet done nor blocked
+		blocked []TaskResult
+		over    chan struct{} //closed once every task is done or blocked
+	}
+)
+
+func (e *CycleError) Error() string {
+	return "dependency cycle: " + strings.Join(e.Keys, " -> ") + " -> " + e.Keys[0]
+}
+
+func (e *DependencyError) Error() string {
+	return fmt.Sprintf("task %s failed: %v", e.Key, e.Err)
+}
+
+//Cause returns the error of the task which failed, it makes DependencyError work with errors.Cause
+func (e *DependencyError) Cause() error {
+	return e.Err
+}
+
+//Unwrap returns the error of the task which failed
+func (e *DependencyError) Unwrap() error {
+	return e.Err
+}
+
+//DoGraph executes tasks made by c.FactoryFunc as DoReport does, each once the tasks it depends on
+//succeeded or were skipped by c.Journal. Every task is made before any is executed, so that
+//an unknown dependency or a cycle is reported before anything runs.
+//Tasks depending on one which did not succeed are reported Blocked, without being executed.
+//Tasks must be comparable, such as pointers. c.Scheduler is not used,
+//tasks ready at the same time start in the order they were made.
+func DoGraph(c *Context) (*Report, error) {
+	g, err := newGraph(c.FactoryFunc)
+	if err != nil {
+		return nil, err
+	}
+
+	gc := *c
+	if gc.Context == nil {
+		gc.Context = context.Background()
+	}
+	g.ctx, g.cancel = context.WithCancel(gc.Context)
+	defer g.cancel()
+	gc.Context = g.ctx
+	gc.FactoryFunc = g.next
+	gc.Scheduler = nil //a Scheduler would wait for tasks to look ahead at
+	onTaskDone := gc.OnTaskDone
+	gc.OnTaskDone = func(res TaskResult) {
+		if onTaskDone != nil {
+			onTaskDone(res)
+		}
+		g.done(res)
+	}
+
+	report := &Report{}
+	h := start(&gc, report)
+	go func() {
+		<-h.ctx.Done() //aborted, or done once the generator returned
+		g.cancel()
+	}()
+	_, err = h.Wait()
+
+	//sequence numbers are the order tasks got ready, give back those of FactoryFunc
+	for i := range report.Results {
+		report.Results[i].Seq = g.seqs[report.Results[i].Task]
+	}
+	report.Results = append(report.Results, g.blocked...)
+	report.sort()
+	if m, ok := err.(MultiError); ok {
+		for _, e := range m {
+			e.Seq = g.seqs[e.Task]
+		}
+		sort.Slice(m, func(i, j int) bool { return m[i].Seq < m[j].Seq })
+	}
+	if e, ok := err.(*TaskError); ok {
+		e.Seq = g.seqs[e.Task]
+	}
+	return report, err
+}
+
+//newGraph makes every task of f and checks their dependencies
+func newGraph(f FactoryFunc) (*graph, error) {
+	g := &graph{seqs: map[Task]int{}, keys: map[string]int{}, over: make(chan struct{})}
+	for {
+		t, err := f()
+		if err != nil {
+			return nil, err
+		}
+		if t == nil {
+			break
+		}
+		if k, ok := t.(Keyer); ok {
+			if _, dup := g.keys[k.Key()]; dup {
+				return nil, errors.Errorf("tasks with the same key %s", k.Key())
+			}
+			g.keys[k.Key()] = len(g.tasks)
+		}
+		if _, dup := g.seqs[t]; dup {
+			return nil, errors.Errorf("task %v made twice", t)
+		}
+		g.seqs[t] = len(g.tasks)
+		g.tasks = append(g.tasks, t)
+	}
+
+	g.deps = make([][]int, len(g.tasks))
+	g.users = make([][]int, len(g.tasks))
+	g.waiting = make([]int, len(g.tasks))
+	for i, t := range g.tasks {
+		d, ok := t.(Dependent)
+		if !ok {
+			continue
+		}
+		for _, key := range d.DependsOn() {
+			j, ok := g.keys[key]
+			if !ok {
+				return nil, errors.Errorf("task %s depends on unknown task %s", d.Key(), key)
+			}
+			g.deps[i] = append(g.deps[i], j)
+			g.users[j] = append(g.users[j], i)
+		}
+		g.waiting[i] = len(g.deps[i])
+	}
+	if cycle := g.cycle(); cycle != nil {
+		return nil, &CycleError{Keys: cycle}
+	}
+
+	g.left = len(g.tasks)
+	g.ready = make(chan Task, len(g.tasks))
+	for i, t := range g.tasks {
+		if g.waiting[i] == 0 {
+			g.ready <- t
+		}
+	}
+	if g.left == 0 {
+		close(g.over)
+	}
+	return g, nil
+}
+
+//cycle returns keys of tasks in a dependency cycle, nil if there is none
+func (g *graph) cycle() []string {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make([]int, len(g.tasks))
+	var path []int
+	var visit func(i int) []int
+	visit = func(i int) []int {
+		state[i] = visiting
+		path = append(path, i)
+		for _, j := range g.deps[i] {
+			switch state[j] {
+			case visiting:
+				for k, p := range path {
+					if p == j {
+						return path[k:]
+					}
+				}
+			case unvisited:
+				if cycle := visit(j); cycle != nil {
+					return cycle
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		state[i] = visited
+		return nil
+	}
+	for i := range g.tasks {
+		if state[i] != unvisited {
+			continue
+		}
+		if cycle := visit(i); cycle != nil {
+			keys := make([]string, len(cycle))
+			for k, j := range cycle {
+				keys[k] = g.tasks[j].(Keyer).Key()
+			}
+			return keys
+		}
+	}
+	return nil
+}
+
+//next is the FactoryFunc of the run, it waits for a task to be ready
+func (g *graph) next() (Task, error) {
+	select {
+	case t := <-g.ready:
+		return t, nil
+	case <-g.over:
+		return nil, nil
+	case <-g.ctx.Done():
+		return nil, nil
+	}
+}
+
+//done readies tasks depending on the task of res once it succeeded, blocks them otherwise
+func (g *graph) done(res TaskResult) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	i := g.seqs[res.Task]
+	g.left--
+	if res.Status == Succeeded || res.Status == Skipped {
+		for _, u := range g.users[i] {
+			if g.waiting[u]--; g.waiting[u] == 0 {
+				g.ready <- g.tasks[u]
+			}
+		}
+	} else if len(g.users[i]) > 0 { //only Keyer tasks are depended on
+		g.block(i, &DependencyError{Key: g.tasks[i].(Keyer).Key(), Err: res.Err}, res)
+	}
+	if g.left == 0 {
+		close(g.over)
+	}
+}
+
+//block reports tasks depending on task i blocked with err, g.mu is held
+func (g *graph) block(i int, err *DependencyError, res TaskResult) {
+	for _, u := range g.users[i] {
+		if g.waiting[u] < 0 { //blocked through another dependency
+			continue
+		}
+		g.waiting[u] = -1
+		g.left--
+		g.blocked = append(g.blocked, TaskResult{Task: g.tasks[u], Seq: u, WorkerID: res.WorkerID, Status: Blocked, Start: res.Start.Add(res.Duration), Err: err})
+		g.block(u, err, res)
+	}
+}
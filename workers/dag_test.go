@@ -0,0 +1,169 @@
+package workers
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+type (
+	//step is a task of a graph which records when it ran
+	step struct {
+		key   string
+		deps  []string
+		err   error
+		g     *gauge
+		clock *stepClock
+		start int
+		end   int
+	}
+
+	//stepClock counts steps starting and ending
+	stepClock struct {
+		mu  sync.Mutex
+		now int
+	}
+)
+
+func (c *stepClock) tick() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now++
+	return c.now
+}
+
+func (s *step) Exec(id WorkerID) error {
+	s.start = s.clock.tick()
+	p := &probe{g: s.g, d: time.Millisecond}
+	p.Exec(id)
+	s.end = s.clock.tick()
+	return s.err
+}
+
+func (s *step) Key() string {
+	return s.key
+}
+
+func (s *step) DependsOn() []string {
+	return s.deps
+}
+
+func (s *step) String() string {
+	return s.key
+}
+
+//steps makes a step per key, deps lists keys each key depends on
+func steps(keys []string, deps map[string][]string) map[string]*step {
+	g, clock := &gauge{}, &stepClock{}
+	all := map[string]*step{}
+	for _, key := range keys {
+		all[key] = &step{key: key, deps: deps[key], g: g, clock: clock}
+	}
+	return all
+}
+
+func stepTasks(keys []string, all map[string]*step) []Task {
+	tasks := []Task{}
+	for _, key := range keys {
+		tasks = append(tasks, all[key])
+	}
+	return tasks
+}
+
+var (
+	//graphKeys makes tasks which depend on tasks made later, as FactoryFunc may
+	graphKeys = []string{"publish", "index", "shard1", "shard2", "shard3", "other"}
+	graphDeps = map[string][]string{
+		"index":   {"shard1", "shard2", "shard3"},
+		"publish": {"index"},
+	}
+)
+
+//TestDoGraph test tasks start once tasks they depend on are done, within DOP
+func TestDoGraph(t *testing.T) {
+	all := steps(graphKeys, graphDeps)
+	report, err := DoGraph(&Context{DOP: 2, FactoryFunc: FromSlice(stepTasks(graphKeys, all))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range all {
+		for _, dep := range s.deps {
+			if all[dep].end == 0 || all[dep].end > s.start {
+				t.Errorf("expected %s to start after %s ended", s.key, dep)
+			}
+		}
+	}
+	if g := all["index"].g; g.max > 2 {
+		t.Errorf("expected 2 tasks running at most, actual %d", g.max)
+	}
+	for i, res := range report.Results {
+		if res.Seq != i || res.Task != all[graphKeys[i]] || res.Status != Succeeded {
+			t.Errorf("expected %s succeeded as result %d, actual %+v", graphKeys[i], i, res)
+		}
+	}
+}
+
+//TestDoGraphFailure test tasks depending on a failed task are blocked, others are executed
+func TestDoGraphFailure(t *testing.T) {
+	all := steps(graphKeys, graphDeps)
+	all["shard2"].err = errTest
+	report, err := DoGraph(&Context{DOP: 3, FactoryFunc: FromSlice(stepTasks(graphKeys, all)), ErrorPolicy: ContinueOnError})
+
+	m, ok := err.(MultiError)
+	if !ok || len(m) != 1 || m[0].Task != all["shard2"] || m[0].Seq != 3 {
+		t.Fatalf("expected shard2 failed, actual %v", err)
+	}
+	if len(report.Results) != len(graphKeys) {
+		t.Fatalf("expected a result per task, actual %d", len(report.Results))
+	}
+	blocked := report.Blocked()
+	if len(blocked) != 2 || blocked[0].Task != all["publish"] || blocked[1].Task != all["index"] {
+		t.Fatalf("expected publish and index blocked, actual %v", blocked)
+	}
+	for _, res := range blocked {
+		var de *DependencyError
+		if !errors.As(res.Err, &de) || de.Key != "shard2" || !errors.Is(res.Err, errTest) {
+			t.Errorf("expected %v blocked by shard2, actual %v", res.Task, res.Err)
+		}
+		if s := res.Task.(*step); s.start != 0 {
+			t.Errorf("expected %s not executed", s.key)
+		}
+	}
+	for _, key := range []string{"shard1", "shard3", "other"} {
+		if all[key].end == 0 {
+			t.Errorf("expected %s executed", key)
+		}
+	}
+}
+
+//TestDoGraphInvalid test cycles and unknown dependencies are reported before any task is executed
+func TestDoGraphInvalid(t *testing.T) {
+	tests := []struct {
+		name     string
+		keys     []string
+		deps     map[string][]string
+		expected string
+	}{
+		{"cycle", []string{"a", "b", "c", "d"}, map[string][]string{"a": {"d"}, "b": {"c"}, "c": {"d"}, "d": {"b"}}, "dependency cycle: d -> b -> c -> d"},
+		{"self", []string{"a"}, map[string][]string{"a": {"a"}}, "dependency cycle: a -> a"},
+		{"unknown", []string{"a", "b"}, map[string][]string{"b": {"c"}}, "task b depends on unknown task c"},
+		{"duplicate", []string{"a", "a"}, nil, "tasks with the same key a"},
+	}
+	for _, tt := range tests {
+		g, clock := &gauge{}, &stepClock{}
+		tasks := []Task{}
+		for _, key := range tt.keys { //not steps, keys may be duplicated
+			tasks = append(tasks, &step{key: key, deps: tt.deps[key], g: g, clock: clock})
+		}
+		_, err := DoGraph(&Context{DOP: 2, FactoryFunc: FromSlice(tasks)})
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("%s: expected %q, actual %v", tt.name, tt.expected, err)
+		}
+		for _, task := range tasks {
+			if task.(*step).start != 0 {
+				t.Errorf("%s: expected no task executed", tt.name)
+			}
+		}
+	}
+}
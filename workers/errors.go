@@ -0,0 +1,78 @@
+package workers
+
+import (
+	"bytes"
+	"fmt"
+)
+
+type (
+	//ErrorPolicy controls how Do reacts when a task fails
+	ErrorPolicy int
+
+	//TaskError is the error of a failed task
+	TaskError struct {
+		Task     Task
+		Seq      int
+		WorkerID WorkerID
+		Err      error
+	}
+
+	//MultiError lists errors of every failed task, ordered by Seq
+	MultiError []*TaskError
+)
+
+const (
+	//FailFast cancels the run once a task fails, this is the default
+	FailFast ErrorPolicy = iota
+	//ContinueOnError executes all tasks and collects every error
+	ContinueOnError
+	//StopAfterFailures stops the run once Context.MaxFailures tasks failed, or the first one if it is <= 0
+	StopAfterFailures
+)
+
+func (e *TaskError) Error() string {
+	return fmt.Sprintf("task #%d %v on worker #%d: %v", e.Seq, e.Task, e.WorkerID, e.Err)
+}
+
+//Cause returns the error returned by Exec, it makes TaskError work with errors.Cause
+func (e *TaskError) Cause() error {
+	return e.Err
+}
+
+//Unwrap returns the error returned by Exec
+func (e *TaskError) Unwrap() error {
+	return e.Err
+}
+
+func (m MultiError) Error() string {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%d tasks failed:", len(m))
+	for _, e := range m {
+		fmt.Fprintf(&b, "\n\t%v", e)
+	}
+	return b.String()
+}
+
+//Unwrap returns errors of failed tasks, it makes errors.Is and errors.As look into every one
+func (m MultiError) Unwrap() []error {
+	errs := make([]error, len(m))
+	for i, e := range m {
+		errs[i] = e
+	}
+	return errs
+}
+
+//multiError returns errors of failed tasks in a report, nil if no task failed
+func multiError(r *Report) error {
+	if r == nil {
+		return nil
+	}
+	var m MultiError
+	for _, res := range r.Failed() {
+		m = append(m, &TaskError{Task: res.Task, Seq: res.Seq, WorkerID: res.WorkerID, Err: res.Err})
+	}
+	if len(m) == 0 {
+		return nil
+	}
+	return m
+}
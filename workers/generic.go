@@ -0,0 +1,143 @@
+//go:build go1.18
+
+package workers
+
+import (
+	"fmt"
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+//mapTask applies fn to an input and keeps its output
+type mapTask[In, Out any] struct {
+	fn  func(context.Context, In) (Out, error)
+	in  In
+	out Out
+}
+
+//implements Task
+func (t *mapTask[In, Out]) Exec(w WorkerID) error {
+	return t.ExecContext(context.Background(), w)
+}
+
+//implements ContextTask
+func (t *mapTask[In, Out]) ExecContext(ctx context.Context, w WorkerID) error {
+	out, err := t.fn(ctx, t.in)
+	if err != nil {
+		return err
+	}
+	t.out = out
+	return nil
+}
+
+//implements fmt.Stringer, errors name the input
+func (t *mapTask[In, Out]) String() string {
+	return fmt.Sprint(t.in)
+}
+
+//Map applies fn to inputs in parallel and returns outputs in the order of inputs.
+//opts controls the run as for Do, nil means one input at a time; its FactoryFunc is not used.
+//An output is the zero value if fn failed on its input, the error is returned as by Do.
+func Map[In, Out any](ctx context.Context, inputs []In, fn func(context.Context, In) (Out, error), opts *Context) ([]Out, error) {
+	i := 0
+	return mapNext(ctx, func(context.Context) (In, bool) {
+		if i == len(inputs) {
+			var zero In
+			return zero, false
+		}
+		i++
+		return inputs[i-1], true
+	}, fn, opts, false)
+}
+
+//MapUnordered applies fn to inputs in parallel and returns outputs of inputs fn succeeded on,
+//in the order they were done
+func MapUnordered[In, Out any](ctx context.Context, inputs []In, fn func(context.Context, In) (Out, error), opts *Context) ([]Out, error) {
+	i := 0
+	return mapNext(ctx, func(context.Context) (In, bool) {
+		if i == len(inputs) {
+			var zero In
+			return zero, false
+		}
+		i++
+		return inputs[i-1], true
+	}, fn, opts, true)
+}
+
+//MapChan applies fn in parallel to inputs received until inputs is closed or ctx is done,
+//outputs are in the order inputs were received, see Map
+func MapChan[In, Out any](ctx context.Context, inputs <-chan In, fn func(context.Context, In) (Out, error), opts *Context) ([]Out, error) {
+	return mapNext(ctx, func(stop context.Context) (In, bool) {
+		select {
+		case in, ok := <-inputs:
+			return in, ok
+		case <-stop.Done():
+			var zero In
+			return zero, false
+		}
+	}, fn, opts, false)
+}
+
+//ForEach applies fn to inputs in parallel, see Map
+func ForEach[In any](ctx context.Context, inputs []In, fn func(context.Context, In) error, opts *Context) error {
+	_, err := Map(ctx, inputs, func(ctx context.Context, in In) (struct{}, error) {
+		return struct{}{}, fn(ctx, in)
+	}, opts)
+	return err
+}
+
+//mapNext applies fn to inputs returned by next until it returns false, next is called by the generator only.
+//next is given a context done once ctx is or the run stops, it must return then rather than wait for an input.
+func mapNext[In, Out any](ctx context.Context, next func(context.Context) (In, bool), fn func(context.Context, In) (Out, error), opts *Context, unordered bool) ([]Out, error) {
+	c := Context{}
+	if opts != nil {
+		c = *opts
+	}
+	c.Context = ctx
+	//not the context of the run, cancelling it on a failure would turn the error returned into context.Canceled
+	stop, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		mu   sync.Mutex
+		made int //guarded by mu, a call of FactoryFunc abandoned by a run stopped may still return
+		done []Out
+	)
+	c.FactoryFunc = func() (Task, error) {
+		in, ok := next(stop)
+		if !ok {
+			return nil, nil
+		}
+		mu.Lock()
+		made++
+		mu.Unlock()
+		return &mapTask[In, Out]{fn: fn, in: in}, nil
+	}
+
+	onTaskDone := c.OnTaskDone
+	c.OnTaskDone = func(res TaskResult) {
+		if onTaskDone != nil {
+			onTaskDone(res)
+		}
+		if res.Err != nil && res.Status != Cancelled && (c.ErrorPolicy == FailFast || res.Status == Panicked && c.AbortOnPanic) {
+			cancel() //the run stops, next must not wait for another input
+		}
+		if unordered && res.Status == Succeeded {
+			mu.Lock()
+			done = append(done, res.Task.(*mapTask[In, Out]).out)
+			mu.Unlock()
+		}
+	}
+
+	report, err := DoReport(&c)
+	if unordered {
+		return done, err
+	}
+	mu.Lock()
+	outs := make([]Out, made) //inputs not executed as the run stopped early keep zero values
+	for _, res := range report.Results {
+		outs[res.Seq] = res.Task.(*mapTask[In, Out]).out
+	}
+	mu.Unlock()
+	return outs, err
+}
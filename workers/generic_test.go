@@ -0,0 +1,128 @@
+//go:build go1.18
+
+package workers
+
+import (
+	"errors"
+	"runtime"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+//itoa converts n to a string after a delay making later inputs finish first
+func itoa(ctx context.Context, n int) (string, error) {
+	if n == 13 {
+		return "", errTest
+	}
+	time.Sleep(time.Duration(10-n%10) * time.Millisecond)
+	return strconv.Itoa(n), nil
+}
+
+func TestMap(t *testing.T) {
+	inputs := []int{}
+	for i := 0; i < 20; i++ {
+		inputs = append(inputs, i)
+	}
+
+	outs, err := Map(context.Background(), inputs, itoa, &Context{DOP: 4, ErrorPolicy: ContinueOnError})
+	var m MultiError
+	if !errors.As(err, &m) || len(m) != 1 || m[0].Seq != 13 || m[0].Task.(interface{ String() string }).String() != "13" {
+		t.Errorf("expected input 13 to fail, actual %v", err)
+	}
+	for i, out := range outs {
+		if i == 13 && out != "" || i != 13 && out != strconv.Itoa(i) {
+			t.Errorf("expected output %d in order, actual %q", i, out)
+		}
+	}
+
+	//outputs of inputs not executed are zero values
+	outs, err = Map(context.Background(), inputs, itoa, &Context{DOP: 1})
+	if !errors.Is(err, errTest) || len(outs) < 14 || outs[12] != "12" || outs[13] != "" {
+		t.Errorf("expected a run stopped at input 13, actual %v %q", err, outs)
+	}
+
+	outs, err = Map(context.Background(), []int{1, 2, 3}, itoa, nil)
+	if err != nil || len(outs) != 3 || outs[2] != "3" {
+		t.Errorf("expected outputs of a run with no options, actual %v %q", err, outs)
+	}
+}
+
+func TestMapUnordered(t *testing.T) {
+	inputs := []int{1, 2, 3, 4, 5, 6, 7, 8, 9}
+	outs, err := MapUnordered(context.Background(), inputs, itoa, &Context{DOP: len(inputs)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	index := map[string]int{}
+	for i, out := range outs {
+		index[out] = i
+	}
+	if len(outs) != len(inputs) || index["9"] > index["1"] {
+		t.Errorf("expected 9 done before 1, actual %q", outs)
+	}
+	sort.Strings(outs)
+	for i, out := range outs {
+		if out != strconv.Itoa(inputs[i]) {
+			t.Errorf("expected output of every input, actual %q", outs)
+			break
+		}
+	}
+}
+
+func TestMapChan(t *testing.T) {
+	inputs := make(chan int)
+	go func() {
+		defer close(inputs)
+		for i := 0; i < 10; i++ {
+			inputs <- i
+		}
+	}()
+	outs, err := MapChan(context.Background(), inputs, itoa, &Context{DOP: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, out := range outs {
+		if out != strconv.Itoa(i) {
+			t.Errorf("expected outputs in the order inputs were received, actual %q", outs)
+			break
+		}
+	}
+}
+
+//TestMapChanFailFast test MapChan returns once an input fails while inputs is idle and open
+func TestMapChanFailFast(t *testing.T) {
+	before := runtime.NumGoroutine()
+	inputs := make(chan int)
+	errc := make(chan error, 1)
+	go func() {
+		_, err := MapChan(context.Background(), inputs, itoa, &Context{DOP: 2})
+		errc <- err
+	}()
+	inputs <- 13
+	select {
+	case err := <-errc:
+		if !errors.Is(err, errTest) {
+			t.Errorf("expected %v, actual %v", errTest, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("MapChan still waiting for inputs after a failure")
+	}
+	checkGoroutines(t, before) //none left waiting for inputs
+}
+
+func TestForEach(t *testing.T) {
+	gauge, probes := createProbes(20, time.Millisecond)
+	err := ForEach(context.Background(), probes, func(ctx context.Context, p Task) error {
+		return p.Exec(0)
+	}, &Context{DOP: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gauge.max > 3 {
+		t.Errorf("expected at most 3 inputs at once, actual %d", gauge.max)
+	}
+}
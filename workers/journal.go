@@ -0,0 +1,114 @@
+package workers
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+type (
+	//Keyer is implemented by tasks with a key identifying them across runs, such as a file name
+	Keyer interface {
+		Key() string
+	}
+
+	//Journal records keys of completed tasks in a file, so a run restarted after being
+	//interrupted skips tasks completed by earlier runs. Tasks which are not Keyer are always executed.
+	//A task is recorded once it returns, it must have synced its output by then to survive a crash.
+	Journal struct {
+		mu   sync.Mutex
+		f    *os.File
+		done map[string]bool
+	}
+)
+
+//OpenJournal opens or creates the journal file name and loads keys it records.
+//A line torn by a crash while being written is dropped, its task is executed again.
+func OpenJournal(name string) (*Journal, error) {
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, errors.Wrap(err, "open journal")
+	}
+	j := &Journal{f: f, done: map[string]bool{}}
+
+	//each line is a quoted key, anything after the last valid line is a torn write
+	var valid int64
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			f.Close()
+			return nil, errors.Wrap(err, "read journal")
+		}
+		key, err := strconv.Unquote(string(bytes.TrimSuffix(line, []byte("\n"))))
+		if err != nil {
+			break
+		}
+		j.done[key] = true
+		valid += int64(len(line))
+	}
+	if err := f.Truncate(valid); err != nil {
+		f.Close()
+		return nil, errors.Wrap(err, "truncate journal")
+	}
+	if _, err := f.Seek(valid, io.SeekStart); err != nil {
+		f.Close()
+		return nil, errors.Wrap(err, "seek journal")
+	}
+	return j, nil
+}
+
+//Done tells if the task of key was completed
+func (j *Journal) Done(key string) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.done[key]
+}
+
+//Record writes key of a completed task and syncs the file, so the key survives a crash once Record returns
+func (j *Journal) Record(key string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if _, err := j.f.WriteString(strconv.Quote(key) + "\n"); err != nil {
+		return errors.Wrap(err, "write journal")
+	}
+	if err := j.f.Sync(); err != nil {
+		return errors.Wrap(err, "sync journal")
+	}
+	j.done[key] = true
+	return nil
+}
+
+//Close closes the journal file
+func (j *Journal) Close() error {
+	return j.f.Close()
+}
+
+//skip returns the result of j if c.Journal recorded it completed
+func (c *Context) skip(j job, w WorkerID) (TaskResult, bool) {
+	k, ok := unwrap(j.Task).(Keyer)
+	if !ok || c.Journal == nil || !c.Journal.Done(k.Key()) {
+		return TaskResult{}, false
+	}
+	res := TaskResult{Task: j.Task, Seq: j.seq, WorkerID: w, Status: Skipped, Start: c.clock().Now()}
+	if c.OnTaskDone != nil {
+		c.OnTaskDone(res)
+	}
+	return res, true
+}
+
+//record writes the key of a task succeeded to c.Journal
+func (c *Context) record(t Task) error {
+	if k, ok := unwrap(t).(Keyer); ok && c.Journal != nil {
+		return c.Journal.Record(k.Key())
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package workers
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+//keyed is a task with a key which counts its executions
+type keyed struct {
+	key   string
+	err   error
+	execs *int32
+	crash bool //exit the process as if it were killed
+}
+
+func (k *keyed) Exec(id WorkerID) error {
+	if k.crash {
+		os.Exit(3)
+	}
+	atomic.AddInt32(k.execs, 1)
+	return k.err
+}
+
+func (k *keyed) Key() string {
+	return k.key
+}
+
+//keyedTasks makes N keyed tasks, task errAt fails and task crashAt exits the process
+func keyedTasks(N, errAt, crashAt int, execs []int32) []Task {
+	tasks := []Task{}
+	for i := 0; i < N; i++ {
+		k := &keyed{key: fmt.Sprintf("file\n%d", i), execs: &execs[i], crash: i == crashAt}
+		if i == errAt {
+			k.err = errTest
+		}
+		tasks = append(tasks, k)
+	}
+	return tasks
+}
+
+func tempJournal(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(dir, "journal")
+}
+
+//TestJournal test a rerun skips tasks succeeded and executes tasks failed
+func TestJournal(t *testing.T) {
+	name := tempJournal(t)
+	defer os.RemoveAll(filepath.Dir(name))
+
+	execs := make([]int32, 10)
+	j, err := OpenJournal(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Do(&Context{DOP: 4, FactoryFunc: FromSlice(keyedTasks(10, 5, -1, execs)), ErrorPolicy: ContinueOnError, Journal: j}); err == nil {
+		t.Fatal("expected task 5 to fail")
+	}
+	j.Close()
+
+	if j, err = OpenJournal(name); err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	report, err := DoReport(&Context{DOP: 4, FactoryFunc: FromSlice(keyedTasks(10, -1, -1, execs)), Journal: j})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, n := range execs {
+		if i != 5 && n != 1 || i == 5 && n != 2 {
+			t.Errorf("task %d executed %d times", i, n)
+		}
+	}
+	if len(report.Skipped()) != 9 || len(report.Failed()) != 0 || report.Results[5].Status != Succeeded {
+		t.Errorf("expected 9 tasks skipped and task 5 succeeded, actual %v", report.Results)
+	}
+}
+
+//TestJournalTornLine test a line partly written before a crash is dropped
+func TestJournalTornLine(t *testing.T) {
+	name := tempJournal(t)
+	defer os.RemoveAll(filepath.Dir(name))
+	if err := ioutil.WriteFile(name, []byte("\"a\"\n\"b\\nc\"\n\"d"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	j, err := OpenJournal(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !j.Done("a") || !j.Done("b\nc") || j.Done("d") {
+		t.Errorf("expected a and b\\nc done, d torn")
+	}
+	if err := j.Record("e"); err != nil {
+		t.Fatal(err)
+	}
+	j.Close()
+
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "\"a\"\n\"b\\nc\"\n\"e\"\n" {
+		t.Errorf("expected torn line replaced, actual %q", b)
+	}
+}
+
+//TestJournalCrash test a run killed halfway is resumed by the next run.
+//The test binary executes itself to run the tasks of the crashed run.
+func TestJournalCrash(t *testing.T) {
+	if name := os.Getenv("WORKERS_JOURNAL"); name != "" {
+		j, err := OpenJournal(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		execs := make([]int32, 20)
+		Do(&Context{DOP: 1, FactoryFunc: FromSlice(keyedTasks(20, -1, 10, execs)), Journal: j})
+		t.Fatal("expected the process to exit at task 10")
+	}
+
+	name := tempJournal(t)
+	defer os.RemoveAll(filepath.Dir(name))
+	cmd := exec.Command(os.Args[0], "-test.run", "^TestJournalCrash$")
+	cmd.Env = append(os.Environ(), "WORKERS_JOURNAL="+name)
+	out, err := cmd.CombinedOutput()
+	if e, ok := err.(*exec.ExitError); !ok || e.ExitCode() != 3 {
+		t.Fatalf("expected the run to crash, actual %v\n%s", err, out)
+	}
+
+	j, err := OpenJournal(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	execs := make([]int32, 20)
+	report, err := DoReport(&Context{DOP: 4, FactoryFunc: FromSlice(keyedTasks(20, -1, -1, execs)), Journal: j})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, n := range execs {
+		if i < 10 && n != 0 || i >= 10 && n != 1 {
+			t.Errorf("task %d executed %d times after the crash", i, n)
+		}
+	}
+	if len(report.Skipped()) != 10 {
+		t.Errorf("expected 10 tasks skipped, actual %d", len(report.Skipped()))
+	}
+}
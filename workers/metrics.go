@@ -0,0 +1,256 @@
+package workers
+
+import (
+	"bytes"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type (
+	//Metrics counts tasks and measures how long they wait and execute.
+	//It implements expvar.Var and serves the Prometheus text format over HTTP.
+	Metrics struct {
+		started   int64 //64-bit atomics first to be aligned on 32-bit platforms
+		succeeded int64
+		failed    int64
+		active    int64
+
+		//Namespace prefixes Prometheus metric names, defaults to "workers"
+		Namespace string
+		//Buckets are increasing upper bounds in seconds of duration histograms, defaults to DefaultBuckets
+		Buckets []float64
+		//WorkerLabels is the number of workers whose executions are also measured apart, labelled by WorkerID.
+		//WorkerIDs keep growing as a Tuner resizes, those from WorkerLabels on share the label "other".
+		//0 means executions are not labelled by worker.
+		WorkerLabels int
+
+		mu        sync.Mutex
+		queueWait *histogram
+		exec      *histogram
+		byWorker  map[string]*histogram //by worker label
+	}
+
+	//histogram counts observations falling in each bucket, the last bucket is +Inf
+	histogram struct {
+		counts []int64
+		count  int64
+		sum    float64
+	}
+)
+
+//DefaultBuckets are upper bounds in seconds of duration histograms, from 1ms to 1m
+var DefaultBuckets = []float64{.001, .005, .01, .05, .1, .5, 1, 5, 10, 60}
+
+//start counts a task starting after waiting in the queue for wait
+func (m *Metrics) start(wait time.Duration) {
+	if m == nil {
+		return
+	}
+	atomic.AddInt64(&m.started, 1)
+	atomic.AddInt64(&m.active, 1)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.queueWait == nil {
+		m.queueWait = m.histogram()
+	}
+	m.queueWait.observe(m.buckets(), wait)
+}
+
+//done counts a task executed
+func (m *Metrics) done(res TaskResult) {
+	if m == nil {
+		return
+	}
+	atomic.AddInt64(&m.active, -1)
+	if res.Status == Succeeded {
+		atomic.AddInt64(&m.succeeded, 1)
+	} else {
+		atomic.AddInt64(&m.failed, 1)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.exec == nil {
+		m.exec = m.histogram()
+	}
+	m.exec.observe(m.buckets(), res.Duration)
+	if m.WorkerLabels <= 0 {
+		return
+	}
+	if m.byWorker == nil {
+		m.byWorker = map[string]*histogram{}
+	}
+	label := "other"
+	if int(res.WorkerID) < m.WorkerLabels {
+		label = strconv.Itoa(int(res.WorkerID))
+	}
+	h, ok := m.byWorker[label]
+	if !ok {
+		h = m.histogram()
+		m.byWorker[label] = h
+	}
+	h.observe(m.buckets(), res.Duration)
+}
+
+//workerLabels returns labels of m.byWorker in WorkerID order, "other" last, m.mu is held
+func (m *Metrics) workerLabels() []string {
+	labels := make([]string, 0, len(m.byWorker))
+	for label := range m.byWorker {
+		labels = append(labels, label)
+	}
+	sort.Slice(labels, func(i, j int) bool {
+		if labels[i] == "other" || labels[j] == "other" {
+			return labels[j] == "other" && labels[i] != "other"
+		}
+		a, _ := strconv.Atoi(labels[i])
+		b, _ := strconv.Atoi(labels[j])
+		return a < b
+	})
+	return labels
+}
+
+func (m *Metrics) buckets() []float64 {
+	if len(m.Buckets) > 0 {
+		return m.Buckets
+	}
+	return DefaultBuckets
+}
+
+func (m *Metrics) histogram() *histogram {
+	return &histogram{counts: make([]int64, len(m.buckets())+1)}
+}
+
+func (h *histogram) observe(buckets []float64, d time.Duration) {
+	i := sort.SearchFloat64s(buckets, d.Seconds())
+	h.counts[i]++
+	h.count++
+	h.sum += d.Seconds()
+}
+
+//Publish exports m with expvar under name, it panics if name is already published
+func (m *Metrics) Publish(name string) {
+	expvar.Publish(name, m)
+}
+
+//String returns m in JSON, it implements expvar.Var
+func (m *Metrics) String() string {
+	type hist struct {
+		Count   int64            `json:"count"`
+		Sum     float64          `json:"sum"`
+		Buckets map[string]int64 `json:"buckets"`
+	}
+	toJSON := func(h *histogram) hist {
+		j := hist{Buckets: map[string]int64{}}
+		if h == nil {
+			return j
+		}
+		j.Count, j.Sum = h.count, h.sum
+		var cum int64
+		for i, le := range m.buckets() {
+			cum += h.counts[i]
+			j.Buckets[formatFloat(le)] = cum
+		}
+		return j
+	}
+
+	v := struct {
+		Started   int64           `json:"tasks_started"`
+		Succeeded int64           `json:"tasks_succeeded"`
+		Failed    int64           `json:"tasks_failed"`
+		Active    int64           `json:"active_workers"`
+		QueueWait hist            `json:"queue_wait_seconds"`
+		Exec      hist            `json:"exec_duration_seconds"`
+		ByWorker  map[string]hist `json:"exec_duration_seconds_by_worker,omitempty"`
+	}{
+		Started:   atomic.LoadInt64(&m.started),
+		Succeeded: atomic.LoadInt64(&m.succeeded),
+		Failed:    atomic.LoadInt64(&m.failed),
+		Active:    atomic.LoadInt64(&m.active),
+	}
+	m.mu.Lock()
+	v.QueueWait = toJSON(m.queueWait)
+	v.Exec = toJSON(m.exec)
+	for label, h := range m.byWorker {
+		if v.ByWorker == nil {
+			v.ByWorker = map[string]hist{}
+		}
+		v.ByWorker[label] = toJSON(h)
+	}
+	m.mu.Unlock()
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%q", err.Error())
+	}
+	return string(b)
+}
+
+//ServeHTTP writes m in the Prometheus text exposition format
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WritePrometheus(w)
+}
+
+//WritePrometheus writes m in the Prometheus text exposition format
+func (m *Metrics) WritePrometheus(w io.Writer) error {
+	ns := m.Namespace
+	if ns == "" {
+		ns = "workers"
+	}
+	var b bytes.Buffer
+	counter := func(name, help, kind string, v int64) {
+		fmt.Fprintf(&b, "# HELP %s_%s %s\n# TYPE %s_%s %s\n%s_%s %d\n", ns, name, help, ns, name, kind, ns, name, v)
+	}
+	counter("tasks_started_total", "Tasks started.", "counter", atomic.LoadInt64(&m.started))
+	counter("tasks_succeeded_total", "Tasks succeeded.", "counter", atomic.LoadInt64(&m.succeeded))
+	counter("tasks_failed_total", "Tasks failed, timed out or panicked.", "counter", atomic.LoadInt64(&m.failed))
+	counter("active_workers", "Workers executing a task.", "gauge", atomic.LoadInt64(&m.active))
+
+	m.mu.Lock()
+	name := ns + "_queue_wait_seconds"
+	fmt.Fprintf(&b, "# HELP %s Time tasks waited from being made to being started.\n# TYPE %s histogram\n", name, name)
+	m.writeHistogram(&b, name, "", m.queueWait)
+
+	name = ns + "_exec_duration_seconds"
+	if m.WorkerLabels <= 0 {
+		fmt.Fprintf(&b, "# HELP %s Time tasks took to execute, retries included.\n# TYPE %s histogram\n", name, name)
+		m.writeHistogram(&b, name, "", m.exec)
+	} else {
+		fmt.Fprintf(&b, "# HELP %s Time tasks took to execute, retries included, per worker.\n# TYPE %s histogram\n", name, name)
+		for _, label := range m.workerLabels() {
+			m.writeHistogram(&b, name, fmt.Sprintf(`worker="%s",`, label), m.byWorker[label])
+		}
+	}
+	m.mu.Unlock()
+
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+//writeHistogram writes cumulative buckets, sum and count of h, labels end with a comma if any
+func (m *Metrics) writeHistogram(b *bytes.Buffer, name, labels string, h *histogram) {
+	if h == nil {
+		h = m.histogram()
+	}
+	var cum int64
+	for i, le := range m.buckets() {
+		cum += h.counts[i]
+		fmt.Fprintf(b, "%s_bucket{%sle=\"%s\"} %d\n", name, labels, formatFloat(le), cum)
+	}
+	fmt.Fprintf(b, "%s_bucket{%sle=\"+Inf\"} %d\n", name, labels, h.count)
+	if labels != "" {
+		labels = "{" + labels[:len(labels)-1] + "}"
+	}
+	fmt.Fprintf(b, "%s_sum%s %s\n%s_count%s %d\n", name, labels, formatFloat(h.sum), name, labels, h.count)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,113 @@
+package workers
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestMetricsPrometheus(t *testing.T) {
+	m := &Metrics{Namespace: "test", WorkerLabels: 2}
+	err := Do(&Context{DOP: 4, FactoryFunc: factoryFuncSquares(100, 10, errTest), ErrorPolicy: ContinueOnError, Metrics: m})
+	if err == nil {
+		t.Fatal("expected task 10 to fail")
+	}
+
+	srv := httptest.NewServer(m)
+	defer srv.Close()
+	resp, err := srv.Client().Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("expected Prometheus text format, actual Content-Type %s", ct)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := string(body)
+
+	for _, line := range []string{
+		"# TYPE test_tasks_started_total counter",
+		"test_tasks_started_total 100",
+		"test_tasks_succeeded_total 99",
+		"test_tasks_failed_total 1",
+		"test_active_workers 0",
+		"# TYPE test_queue_wait_seconds histogram",
+		`test_queue_wait_seconds_bucket{le="+Inf"} 100`,
+		"test_queue_wait_seconds_count 100",
+		"# TYPE test_exec_duration_seconds histogram",
+		`test_exec_duration_seconds_bucket{worker="0",le="+Inf"}`,
+	} {
+		if !strings.Contains(text, line+"\n") && !strings.Contains(text, line+" ") {
+			t.Errorf("expected line %q in\n%s", line, text)
+		}
+	}
+
+	//executions counted per worker add up to all tasks, workers from the second on share a label
+	var count int
+	for _, line := range strings.Split(text, "\n") {
+		if !strings.HasPrefix(line, "test_exec_duration_seconds_count") {
+			continue
+		}
+		if label := line[:strings.LastIndex(line, " ")]; label != `test_exec_duration_seconds_count{worker="0"}` &&
+			label != `test_exec_duration_seconds_count{worker="1"}` && label != `test_exec_duration_seconds_count{worker="other"}` {
+			t.Errorf("expected workers 0, 1 and other labelled, actual %s", line)
+		}
+		n, err := strconv.Atoi(line[strings.LastIndex(line, " ")+1:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		count += n
+	}
+	if count != 100 {
+		t.Errorf("expected 100 executions over all workers, actual %d", count)
+	}
+}
+
+func TestMetricsExpvar(t *testing.T) {
+	m := &Metrics{}
+	if err := Do(&Context{DOP: 2, FactoryFunc: factoryFuncSquares(10, -1, nil), Metrics: m}); err != nil {
+		t.Fatal(err)
+	}
+	name := fmt.Sprintf("workers_test_%p", m) //expvar names are published once per process
+	m.Publish(name)
+
+	rec := httptest.NewRecorder()
+	expvar.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/debug/vars", nil))
+	var vars map[string]json.RawMessage
+	if err := json.Unmarshal(rec.Body.Bytes(), &vars); err != nil {
+		t.Fatal(err)
+	}
+	var metrics struct {
+		Started   int64 `json:"tasks_started"`
+		Succeeded int64 `json:"tasks_succeeded"`
+		QueueWait struct {
+			Count   int64            `json:"count"`
+			Buckets map[string]int64 `json:"buckets"`
+		} `json:"queue_wait_seconds"`
+		Exec struct {
+			Count int64 `json:"count"`
+		} `json:"exec_duration_seconds"`
+		ByWorker map[string]json.RawMessage `json:"exec_duration_seconds_by_worker"`
+	}
+	if err := json.Unmarshal(vars[name], &metrics); err != nil {
+		t.Fatal(err)
+	}
+	if metrics.Started != 10 || metrics.Succeeded != 10 || metrics.QueueWait.Count != 10 {
+		t.Errorf("expected 10 tasks started, succeeded and waited, actual %+v", metrics)
+	}
+	if metrics.Exec.Count != 10 || metrics.ByWorker != nil {
+		t.Errorf("expected 10 executions not labelled by worker, actual %+v", metrics)
+	}
+	if metrics.QueueWait.Buckets["60"] != 10 {
+		t.Errorf("expected 10 tasks waiting less than 60s, actual %v", metrics.QueueWait.Buckets)
+	}
+}
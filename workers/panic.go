@@ -0,0 +1,29 @@
+package workers
+
+import (
+	"fmt"
+	"runtime/debug"
+
+	"golang.org/x/net/context"
+)
+
+//PanicError is the error of a task which panicked
+type PanicError struct {
+	Task  Task
+	Value interface{} //value passed to panic
+	Stack []byte      //stack trace of the panicking goroutine
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("task %v panicked: %v", e.Task, e.Value)
+}
+
+//safeExec executes t once and converts a panic into a PanicError
+func safeExec(ctx context.Context, t Task, w WorkerID) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &PanicError{Task: t, Value: r, Stack: debug.Stack()}
+		}
+	}()
+	return Adapt(t).ExecContext(ctx, w)
+}
@@ -0,0 +1,69 @@
+package workers
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+//bomb panics when executed
+type bomb struct {
+	name string
+}
+
+//bomb implements Task
+func (b bomb) Exec(id WorkerID) error {
+	var m map[string]int
+	m[b.name]++ //assignment to entry in nil map
+	return nil
+}
+
+func (b bomb) String() string { return b.name }
+
+//TestPanic test panics are converted into PanicErrors
+func TestPanic(t *testing.T) {
+	tests := []struct {
+		abortOnPanic bool
+		maxExecuted  int
+	}{
+		{false, 10},
+		{true, 3}, //tasks running when the run is aborted finish
+	}
+	for _, tt := range tests {
+		_, tasks := createProbes(10, 10*time.Millisecond)
+		tasks[1] = bomb{"bomb.tar"}
+		c := Context{
+			DOP:          2,
+			FactoryFunc:  factoryFuncOf(tasks...),
+			ErrorPolicy:  ContinueOnError,
+			Retry:        &RetryPolicy{MaxAttempts: 3},
+			AbortOnPanic: tt.abortOnPanic,
+		}
+		report, err := DoReport(&c)
+
+		var pe *PanicError
+		if !errors.As(err, &pe) {
+			t.Fatalf("abort %v: expected PanicError, actual %v", tt.abortOnPanic, err)
+		}
+		if _, ok := err.(*PanicError); ok != tt.abortOnPanic {
+			t.Errorf("abort %v: expected PanicError returned as is %v, actual %T", tt.abortOnPanic, tt.abortOnPanic, err)
+		}
+		if pe.Task != tasks[1] || !strings.Contains(pe.Error(), "bomb.tar") || !strings.Contains(string(pe.Stack), "bomb.Exec") {
+			t.Errorf("abort %v: expected task and stack in %v\n%s", tt.abortOnPanic, pe, pe.Stack)
+		}
+
+		if len(report.Results) > tt.maxExecuted {
+			t.Errorf("abort %v: expected at most %d tasks executed, actual %d", tt.abortOnPanic, tt.maxExecuted, len(report.Results))
+		}
+		var res *TaskResult
+		for i := range report.Results {
+			if report.Results[i].Task == tasks[1] {
+				res = &report.Results[i]
+			}
+		}
+		if res == nil || res.Status != Panicked || res.Attempts != 1 {
+			t.Errorf("abort %v: expected task panicked once, actual %+v", tt.abortOnPanic, res)
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package workers
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	"golang.org/x/sync/errgroup"
+)
+
+type (
+	//StageFunc processes an item and hands its results to the next stage by calling emit
+	StageFunc func(ctx context.Context, item interface{}, emit func(interface{}) error) error
+
+	//Stage is a step of a Pipeline executed by workers of its own
+	Stage struct {
+		Name string
+		Func StageFunc
+		//Buffer is the number of items queued ahead of the stage, defaults to its DOP.
+		//A full queue blocks the stage before it, so a slow stage holds back the ones ahead.
+		Buffer int
+		//Workers configures workers of the stage, its Context and FactoryFunc are set by the Pipeline
+		Workers Context
+	}
+
+	//Pipeline passes items from Source through Stages in turn, stages run concurrently
+	Pipeline struct {
+		context.Context
+		//Source hands items to the first stage by calling emit, an error stops the Pipeline
+		Source func(ctx context.Context, emit func(interface{}) error) error
+		Stages []*Stage
+		//Drain stops the Source once done, emit then returns ErrDrained; items emitted before go
+		//through all stages unless Context is done. Run then returns ErrDrained, see NotifyShutdown.
+		Drain context.Context
+	}
+
+	//StageTask is the task of a stage processing one item
+	StageTask struct {
+		Stage *Stage
+		Item  interface{}
+		out   *stageQueue
+	}
+
+	//StageError is the error of a stage, or of the source, which failed
+	StageError struct {
+		Stage string
+		Err   error
+	}
+
+	//stageQueue is a bounded queue between two stages
+	stageQueue struct {
+		ch     chan interface{}
+		mu     sync.RWMutex
+		closed bool
+	}
+)
+
+func (e *StageError) Error() string {
+	return fmt.Sprintf("stage %s: %v", e.Stage, e.Err)
+}
+
+//Cause returns the error of the stage, it makes StageError work with errors.Cause
+func (e *StageError) Cause() error {
+	return e.Err
+}
+
+//Unwrap returns the error of the stage
+func (e *StageError) Unwrap() error {
+	return e.Err
+}
+
+//implements Task
+func (t *StageTask) Exec(w WorkerID) error {
+	return t.ExecContext(context.Background(), w)
+}
+
+//implements ContextTask
+func (t *StageTask) ExecContext(ctx context.Context, w WorkerID) error {
+	return t.Stage.Func(ctx, t.Item, func(item interface{}) error {
+		return t.out.send(ctx, nil, item)
+	})
+}
+
+//implements Cleaner, the item cleans up if it is a Cleaner
+func (t *StageTask) Cleanup(err error) {
+	cleanup(t.Item, err)
+}
+
+//implements Coster, the item declares its cost if it is a Coster
+func (t *StageTask) Cost() Resources {
+	if c, ok := t.Item.(Coster); ok {
+		return c.Cost()
+	}
+	return Resources{}
+}
+
+//implements fmt.Stringer
+func (t *StageTask) String() string {
+	return fmt.Sprintf("%s %v", t.Stage.Name, t.Item)
+}
+
+//send blocks until item is queued, ctx or drain is done, items sent to no queue are dropped
+func (q *stageQueue) send(ctx context.Context, drain <-chan struct{}, item interface{}) error {
+	select {
+	case <-drain:
+		return ErrDrained
+	default:
+	}
+	if q == nil {
+		return nil
+	}
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.closed {
+		return errors.New("item emitted after stage is done")
+	}
+	select {
+	case q.ch <- item:
+		return nil
+	case <-drain:
+		return ErrDrained
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+//close tells the next stage no more items come, tasks abandoned on timeout may still call send
+func (q *stageQueue) close() {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	close(q.ch)
+}
+
+func (s *Stage) buffer() int {
+	if s.Buffer > 0 {
+		return s.Buffer
+	}
+	if s.Workers.DOP > 1 {
+		return s.Workers.DOP
+	}
+	return 1
+}
+
+//run executes items received from in until in is closed, drained tells if every item was taken
+func (s *Stage) run(ctx context.Context, in, out *stageQueue) (r *Report, drained bool, err error) {
+	var ended int32 //set by FactoryFunc, whose call may be abandoned by a run stopped
+	c := s.Workers
+	c.Context = ctx
+	c.FactoryFunc = func() (Task, error) {
+		select {
+		case item, ok := <-in.ch:
+			if !ok {
+				atomic.StoreInt32(&ended, 1)
+				return nil, nil
+			}
+			return &StageTask{Stage: s, Item: item, out: out}, nil
+		case <-ctx.Done():
+			return nil, nil
+		}
+	}
+	r, err = DoReport(&c)
+	return r, atomic.LoadInt32(&ended) == 1, err
+}
+
+//Run executes the Pipeline until every item went through all stages and returns reports of stages in order.
+//Once the source or a stage stops before taking all its items, every stage is cancelled and its error returned.
+//Errors of a stage which took all its items, e.g. under ContinueOnError, are returned after all stages are done.
+func (p *Pipeline) Run() ([]*Report, error) {
+	if p.Context == nil {
+		p.Context = context.Background()
+	}
+	g, ctx := errgroup.WithContext(p.Context)
+
+	queues := make([]*stageQueue, len(p.Stages)+1) //no queue after the last stage
+	for i, s := range p.Stages {
+		queues[i] = &stageQueue{ch: make(chan interface{}, s.buffer())}
+	}
+
+	var (
+		drain    <-chan struct{}
+		drainErr error
+	)
+	if p.Drain != nil {
+		drain = p.Drain.Done()
+	}
+	g.Go(func() error {
+		defer queues[0].close()
+		err := p.Source(ctx, func(item interface{}) error { return queues[0].send(ctx, drain, item) })
+		if err == nil {
+			return nil
+		}
+		err = &StageError{Stage: "source", Err: err}
+		if errors.Cause(err) == ErrDrained { //let stages finish items emitted
+			drainErr = err
+			return nil
+		}
+		return err
+	})
+
+	reports := make([]*Report, len(p.Stages))
+	errs := make([]error, len(p.Stages))
+	for i, s := range p.Stages {
+		i, s := i, s
+		g.Go(func() error {
+			defer queues[i+1].close()
+			r, drained, err := s.run(ctx, queues[i], queues[i+1])
+			reports[i] = r
+			if err == nil {
+				return nil
+			}
+			err = &StageError{Stage: s.Name, Err: err}
+			if !drained { //items are left in the queue, the stages before would block
+				return err
+			}
+			errs[i] = err
+			return nil
+		})
+	}
+
+	if err := g.Wait(); err != nil {
+		return reports, err
+	}
+	for _, err := range errs {
+		if err != nil {
+			return reports, err
+		}
+	}
+	return reports, drainErr
+}
@@ -0,0 +1,170 @@
+package workers
+
+import (
+	"errors"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+//count emits 0..n-1, or fails with err once n items are emitted
+func count(n int, err error) func(ctx context.Context, emit func(interface{}) error) error {
+	return func(ctx context.Context, emit func(interface{}) error) error {
+		for i := 0; i < n; i++ {
+			if e := emit(i); e != nil {
+				return e
+			}
+		}
+		return err
+	}
+}
+
+//squareStage emits the square of an item, it fails on item errAt
+func squareStage(errAt int) StageFunc {
+	return func(ctx context.Context, item interface{}, emit func(interface{}) error) error {
+		n := item.(int)
+		if n == errAt {
+			return errTest
+		}
+		return emit(n * n)
+	}
+}
+
+//sumStage adds items to sum
+func sumStage(sum *int64) StageFunc {
+	return func(ctx context.Context, item interface{}, emit func(interface{}) error) error {
+		atomic.AddInt64(sum, int64(item.(int)))
+		return nil
+	}
+}
+
+func TestPipeline(t *testing.T) {
+	var sum int64
+	p := &Pipeline{
+		Source: count(100, nil),
+		Stages: []*Stage{
+			{Name: "square", Func: squareStage(-1), Workers: Context{DOP: 4}},
+			{Name: "sum", Func: sumStage(&sum), Workers: Context{DOP: 2}},
+		},
+	}
+	reports, err := p.Run()
+	if err != nil {
+		t.Fatalf("expected no error, actual %v", err)
+	}
+	if sum != 328350 {
+		t.Errorf("expected sum of squares 328350, actual %d", sum)
+	}
+	for i, r := range reports {
+		if len(r.Results) != 100 {
+			t.Errorf("expected 100 results of stage %d, actual %d", i, len(r.Results))
+		}
+	}
+}
+
+func TestPipelineError(t *testing.T) {
+	tests := []struct {
+		name   string
+		source error
+		errAt  int
+		policy ErrorPolicy
+		stage  string
+		summed int64 //-1 when items after the failure may be summed or not
+	}{
+		{"source error", errTest, -1, FailFast, "source", -1},
+		{"fail fast", nil, 10, FailFast, "square", -1},
+		{"continue on error", nil, 10, ContinueOnError, "square", 99},
+	}
+
+	for _, tt := range tests {
+		before := runtime.NumGoroutine()
+		var sum int64
+		var summed int64
+		p := &Pipeline{
+			Source: count(100, tt.source),
+			Stages: []*Stage{
+				{Name: "square", Func: squareStage(tt.errAt), Workers: Context{DOP: 4, ErrorPolicy: tt.policy}},
+				{Name: "sum", Func: func(ctx context.Context, item interface{}, emit func(interface{}) error) error {
+					atomic.AddInt64(&summed, 1)
+					return sumStage(&sum)(ctx, item, emit)
+				}},
+			},
+		}
+		_, err := p.Run()
+		var se *StageError
+		if !errors.As(err, &se) || se.Stage != tt.stage || !errors.Is(err, errTest) {
+			t.Errorf("%s: expected error of stage %s, actual %v", tt.name, tt.stage, err)
+		}
+		if tt.summed >= 0 && summed != tt.summed {
+			t.Errorf("%s: expected %d items summed, actual %d", tt.name, tt.summed, summed)
+		}
+		checkGoroutines(t, before)
+	}
+}
+
+//TestPipelineBackpressure test a slow stage holds back the source
+func TestPipelineBackpressure(t *testing.T) {
+	var emitted, done int64
+	var ahead int64 //most items emitted but not done
+	p := &Pipeline{
+		Source: func(ctx context.Context, emit func(interface{}) error) error {
+			for i := 0; i < 50; i++ {
+				if err := emit(i); err != nil {
+					return err
+				}
+				if n := atomic.AddInt64(&emitted, 1) - atomic.LoadInt64(&done); n > atomic.LoadInt64(&ahead) {
+					atomic.StoreInt64(&ahead, n)
+				}
+			}
+			return nil
+		},
+		Stages: []*Stage{
+			{Name: "pass", Func: func(ctx context.Context, item interface{}, emit func(interface{}) error) error {
+				return emit(item)
+			}, Buffer: 2, Workers: Context{DOP: 2}},
+			{Name: "slow", Func: func(ctx context.Context, item interface{}, emit func(interface{}) error) error {
+				time.Sleep(time.Millisecond)
+				atomic.AddInt64(&done, 1)
+				return nil
+			}, Buffer: 2},
+		},
+	}
+	if _, err := p.Run(); err != nil {
+		t.Fatalf("expected no error, actual %v", err)
+	}
+	//2 queued and 2 executing in pass, 2 queued and 1 executing in slow, 1 held by each generator
+	if ahead > 10 {
+		t.Errorf("expected at most 10 items ahead of the slow stage, actual %d", ahead)
+	}
+}
+
+//TestPipelineCancel test cancelling the Pipeline context stops every stage
+func TestPipelineCancel(t *testing.T) {
+	before := runtime.NumGoroutine()
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &Pipeline{
+		Context: ctx,
+		Source: func(ctx context.Context, emit func(interface{}) error) error {
+			for i := 0; ; i++ {
+				if err := emit(i); err != nil {
+					return err
+				}
+			}
+		},
+		Stages: []*Stage{
+			{Name: "wait", Func: func(ctx context.Context, item interface{}, emit func(interface{}) error) error {
+				if item.(int) == 10 {
+					cancel()
+				}
+				return emit(item)
+			}, Workers: Context{DOP: 2}},
+			{Name: "drop", Func: sumStage(new(int64))},
+		},
+	}
+	if _, err := p.Run(); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected %v, actual %v", context.Canceled, err)
+	}
+	checkGoroutines(t, before)
+}
@@ -0,0 +1,225 @@
+package workers
+
+import (
+	"sync"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+type (
+	//Pool is a long-lived set of workers executing tasks submitted over time,
+	//it saves starting and stopping workers for each batch as Do does
+	Pool struct {
+		h      *Handle
+		queue  chan submitted
+		ctx    context.Context //done once the run of the pool stopped, it unblocks the generator
+		cancel context.CancelFunc
+
+		mu     sync.RWMutex //held for reading while submitting, for writing to close queue
+		closed bool
+
+		fmu     sync.Mutex
+		futures map[int]*Future //by Seq of tasks handed to workers
+		seq     int
+		pending int //tasks submitted and not done
+		idle    *sync.Cond
+		over    chan struct{} //closed once futures left behind by a stopped run are failed
+	}
+
+	//Future is the result of a task submitted to a Pool
+	Future struct {
+		done chan struct{}
+		res  TaskResult
+	}
+
+	submitted struct {
+		Task
+		f *Future
+	}
+)
+
+//ErrPoolClosed is returned by Submit once the Pool is closed or its run stopped
+var ErrPoolClosed = errors.New("pool closed")
+
+//NewPool starts c.DOP workers waiting for tasks. Submit blocks once queueSize tasks,
+//plus the one about to be handed to the next free worker, wait for a worker.
+//c.FactoryFunc and c.Scheduler are not used; each Future reports the error of its task,
+//so ErrorPolicy only matters as StopAfterFailures.
+func NewPool(c *Context, queueSize int) *Pool {
+	pc := *c
+	if pc.Context == nil {
+		pc.Context = context.Background()
+	}
+	p := &Pool{queue: make(chan submitted, queueSize), futures: map[int]*Future{}, over: make(chan struct{})}
+	p.idle = sync.NewCond(&p.fmu)
+	p.ctx, p.cancel = context.WithCancel(pc.Context)
+	pc.Context = p.ctx
+	pc.FactoryFunc = p.next
+	//next registers the future of the task it takes, abandon must see it
+	pc.waitFactory = true
+	pc.Scheduler = nil //a Scheduler would wait for tasks to look ahead at
+	if pc.ErrorPolicy == FailFast {
+		pc.ErrorPolicy = ContinueOnError
+	}
+	onTaskDone := pc.OnTaskDone
+	pc.OnTaskDone = func(res TaskResult) {
+		if onTaskDone != nil {
+			onTaskDone(res)
+		}
+		p.complete(res)
+	}
+
+	p.h = start(&pc, nil)
+	go func() {
+		select {
+		case <-p.h.ctx.Done(): //aborted, or done once the queue is closed
+		case <-p.ctx.Done():
+		}
+		p.cancel()
+		<-p.h.done
+		p.abandon()
+	}()
+	return p
+}
+
+//next is the FactoryFunc of the pool, it waits for a task to be submitted
+func (p *Pool) next() (Task, error) {
+	select {
+	case s, ok := <-p.queue:
+		if !ok {
+			return nil, nil
+		}
+		p.fmu.Lock()
+		p.futures[p.seq] = s.f //the generator gives tasks made in turn sequence numbers from 0
+		p.seq++
+		p.fmu.Unlock()
+		return s.Task, nil
+	case <-p.ctx.Done():
+		return nil, nil
+	}
+}
+
+//complete hands res to the future of its task
+func (p *Pool) complete(res TaskResult) {
+	p.fmu.Lock()
+	f := p.futures[res.Seq]
+	delete(p.futures, res.Seq)
+	p.fmu.Unlock()
+	p.resolve(f, res)
+}
+
+//resolve sets the result of f and wakes up Wait once no task is pending
+func (p *Pool) resolve(f *Future, res TaskResult) {
+	f.res = res
+	close(f.done)
+	p.fmu.Lock()
+	p.pending--
+	if p.pending == 0 {
+		p.idle.Broadcast()
+	}
+	p.fmu.Unlock()
+}
+
+//abandon fails futures of tasks the stopped run will not execute
+func (p *Pool) abandon() {
+	defer close(p.over)
+	p.mu.Lock()
+	p.closed = true //submitters blocked on a full queue are gone now
+	p.mu.Unlock()
+
+	err := p.h.err
+	if err == nil {
+		err = ErrPoolClosed
+	}
+	p.fmu.Lock()
+	left := p.futures
+	p.futures = map[int]*Future{}
+	p.fmu.Unlock()
+	for seq, f := range left {
+		p.resolve(f, TaskResult{Seq: seq, Status: Failed, Err: err})
+	}
+	for {
+		select {
+		case s, ok := <-p.queue:
+			if !ok {
+				return
+			}
+			p.resolve(s.f, TaskResult{Task: s.Task, Status: Failed, Err: err})
+		default:
+			return
+		}
+	}
+}
+
+//Submit queues t and returns its Future, it blocks while the queue is full until ctx is done
+func (p *Pool) Submit(ctx context.Context, t Task) (*Future, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return nil, ErrPoolClosed
+	}
+	f := &Future{done: make(chan struct{})}
+	p.fmu.Lock()
+	p.pending++
+	p.fmu.Unlock()
+	select {
+	case p.queue <- submitted{Task: t, f: f}:
+		return f, nil
+	case <-ctx.Done():
+		p.resolve(f, TaskResult{})
+		return nil, ctx.Err()
+	case <-p.ctx.Done():
+		p.resolve(f, TaskResult{})
+		return nil, ErrPoolClosed
+	}
+}
+
+//Wait blocks until every task submitted is done
+func (p *Pool) Wait() {
+	p.fmu.Lock()
+	defer p.fmu.Unlock()
+	for p.pending > 0 {
+		p.idle.Wait()
+	}
+}
+
+//Close stops taking tasks, waits for tasks submitted to be done and stops workers.
+//It returns the error which stopped the run, if any, such as a panic under AbortOnPanic.
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.queue)
+	}
+	p.mu.Unlock()
+	_, err := p.h.Wait()
+	<-p.over
+	return err
+}
+
+//Resize changes the number of workers to n, see Handle.Resize
+func (p *Pool) Resize(n int) {
+	p.h.Resize(n)
+}
+
+//Stats returns counters of tasks executed so far
+func (p *Pool) Stats() Stats {
+	return p.h.Stats()
+}
+
+//Done returns a channel closed once the task is done
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
+
+//Result waits for the task to be done and returns its result
+func (f *Future) Result() TaskResult {
+	<-f.done
+	return f.res
+}
+
+//Err waits for the task to be done and returns its error
+func (f *Future) Err() error {
+	return f.Result().Err
+}
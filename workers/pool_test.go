@@ -0,0 +1,210 @@
+package workers
+
+import (
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+//TestPool test tasks submitted concurrently yield their own results
+func TestPool(t *testing.T) {
+	before := runtime.NumGoroutine()
+	p := NewPool(&Context{DOP: 4}, 8)
+
+	var wg sync.WaitGroup
+	futures := make([]*Future, 100)
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := g; i < len(futures); i += 4 {
+				s := &square{n: i}
+				if i == 50 {
+					s.err = errTest
+				}
+				f, err := p.Submit(context.Background(), s)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				futures[i] = f
+			}
+		}(g)
+	}
+	wg.Wait()
+	p.Wait()
+
+	for i, f := range futures {
+		select {
+		case <-f.Done():
+		default:
+			t.Fatalf("expected task %d done after Wait", i)
+		}
+		res := f.Result()
+		if i == 50 {
+			if res.Err != errTest || res.Status != Failed {
+				t.Errorf("expected task 50 failed with %v, actual %v %v", errTest, res.Status, res.Err)
+			}
+			continue
+		}
+		if res.Err != nil || res.Value != i*i || res.Task.(*square).n != i {
+			t.Errorf("expected square of %d, actual %v %v", i, res.Value, res.Err)
+		}
+	}
+	if s := p.Stats(); s.Done != 100 || s.Failed != 1 {
+		t.Errorf("expected 100 tasks done and 1 failed, actual %+v", s)
+	}
+
+	if err := p.Close(); err != nil {
+		t.Errorf("expected a failed task not to stop the pool, actual %v", err)
+	}
+	if _, err := p.Submit(context.Background(), &square{}); err != ErrPoolClosed {
+		t.Errorf("expected %v after Close, actual %v", ErrPoolClosed, err)
+	}
+	checkGoroutines(t, before)
+}
+
+//gate is a task which blocks until released
+type gate struct {
+	started, release chan struct{}
+}
+
+func (g *gate) Exec(id WorkerID) error {
+	close(g.started)
+	<-g.release
+	return nil
+}
+
+//TestPoolBounded test Submit blocks while the queue is full
+func TestPoolBounded(t *testing.T) {
+	p := NewPool(&Context{DOP: 1}, 1)
+	defer p.Close()
+	running := &gate{started: make(chan struct{}), release: make(chan struct{})}
+	if _, err := p.Submit(context.Background(), running); err != nil {
+		t.Fatal(err)
+	}
+	<-running.started
+	//one task is held for the next worker, one waits in the queue
+	queued := []*Future{}
+	for i := 0; i < 2; i++ {
+		f, err := p.Submit(context.Background(), &square{n: i})
+		if err != nil {
+			t.Fatal(err)
+		}
+		queued = append(queued, f)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := p.Submit(ctx, &square{n: 3}); err != context.DeadlineExceeded {
+		t.Errorf("expected %v on a full queue, actual %v", context.DeadlineExceeded, err)
+	}
+
+	close(running.release)
+	for _, f := range queued {
+		if err := f.Err(); err != nil {
+			t.Errorf("expected task queued to succeed, actual %v", err)
+		}
+	}
+}
+
+//TestPoolClose test Close executes tasks queued before returning
+func TestPoolClose(t *testing.T) {
+	gauge, probes := createProbes(20, time.Millisecond)
+	p := NewPool(&Context{DOP: 2}, 20)
+	futures := []*Future{}
+	for _, probe := range probes {
+		f, err := p.Submit(context.Background(), probe)
+		if err != nil {
+			t.Fatal(err)
+		}
+		futures = append(futures, f)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for i, f := range futures {
+		if err := f.Err(); err != nil {
+			t.Errorf("expected task %d to succeed, actual %v", i, err)
+		}
+	}
+	if gauge.max > 2 {
+		t.Errorf("expected at most 2 tasks at once, actual %d", gauge.max)
+	}
+}
+
+//TestPoolAbort test futures of tasks left behind fail once the pool run is stopped
+func TestPoolAbort(t *testing.T) {
+	before := runtime.NumGoroutine()
+	p := NewPool(&Context{DOP: 1, AbortOnPanic: true}, 10)
+	boom, err := p.Submit(context.Background(), &bomb{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	left := []*Future{}
+	for i := 0; i < 5; i++ {
+		if f, err := p.Submit(context.Background(), &square{n: i}); err == nil {
+			left = append(left, f)
+		}
+	}
+
+	if _, ok := boom.Err().(*PanicError); !ok {
+		t.Errorf("expected panic, actual %v", boom.Err())
+	}
+	for _, f := range left {
+		if f.Err() == nil && f.Result().Status != Succeeded {
+			t.Errorf("expected task left behind to fail, actual %+v", f.Result())
+		}
+	}
+	p.Wait()
+	if _, ok := p.Close().(*PanicError); !ok {
+		t.Errorf("expected Close to return the panic")
+	}
+	if _, err := p.Submit(context.Background(), &square{}); err != ErrPoolClosed {
+		t.Errorf("expected %v once aborted, actual %v", ErrPoolClosed, err)
+	}
+	checkGoroutines(t, before)
+}
+
+func squareTasks(n int) []Task {
+	tasks := make([]Task, n)
+	for i := range tasks {
+		tasks[i] = &square{n: i}
+	}
+	return tasks
+}
+
+//BenchmarkDo executes small batches each with Do
+func BenchmarkDo(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		if err := Do(&Context{DOP: 4, FactoryFunc: FromSlice(squareTasks(10))}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+//BenchmarkPool executes small batches each submitted to the same Pool
+func BenchmarkPool(b *testing.B) {
+	p := NewPool(&Context{DOP: 4}, 10)
+	defer p.Close()
+	ctx := context.Background()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		futures := make([]*Future, 0, 10)
+		for _, t := range squareTasks(10) {
+			f, err := p.Submit(ctx, t)
+			if err != nil {
+				b.Fatal(err)
+			}
+			futures = append(futures, f)
+		}
+		for _, f := range futures {
+			if err := f.Err(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
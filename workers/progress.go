@@ -0,0 +1,62 @@
+package workers
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+//Progress periodically reports tasks done, throughput and, when Total is known, ETA
+type Progress struct {
+	//Total is the number of tasks FactoryFunc makes, 0 means unknown
+	Total int
+	//Interval is how often progress is reported, defaults to 5s
+	Interval time.Duration
+	//Logger receives progress lines, nil means the standard logger
+	Logger *log.Logger
+}
+
+//run reports progress of h until all workers exited
+func (p *Progress) run(h *Handle) {
+	interval := p.Interval
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	clock := h.c.clock()
+	start := clock.Now()
+	ticker := clock.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-h.stopped:
+			p.print(h.Stats(), clock.Now().Sub(start))
+			return
+		case now := <-ticker.C():
+			p.print(h.Stats(), now.Sub(start))
+		}
+	}
+}
+
+func (p *Progress) print(s Stats, elapsed time.Duration) {
+	line := p.format(s, elapsed)
+	if p.Logger != nil {
+		p.Logger.Println(line)
+		return
+	}
+	log.Println(line)
+}
+
+//format describes progress after tasks were executed for elapsed time
+func (p *Progress) format(s Stats, elapsed time.Duration) string {
+	throughput := float64(s.Done) / elapsed.Seconds()
+	line := fmt.Sprintf("%d", s.Done)
+	if p.Total > 0 {
+		line += fmt.Sprintf("/%d", p.Total)
+	}
+	line += fmt.Sprintf(" tasks done, %d failed, %.1f tasks/s", s.Failed, throughput)
+	if p.Total > 0 && s.Done > 0 && int(s.Done) < p.Total {
+		eta := time.Duration(float64(p.Total-int(s.Done)) / throughput * float64(time.Second))
+		line += fmt.Sprintf(", ETA %v", eta.Round(time.Second))
+	}
+	return line
+}
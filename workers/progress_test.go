@@ -0,0 +1,67 @@
+package workers
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+//TestProgressFormat test progress lines with and without Total
+func TestProgressFormat(t *testing.T) {
+	tests := []struct {
+		total    int
+		stats    Stats
+		elapsed  time.Duration
+		expected string
+	}{
+		{0, Stats{Done: 10}, 2 * time.Second, "10 tasks done, 0 failed, 5.0 tasks/s"},
+		{100, Stats{Done: 10, Failed: 1}, 2 * time.Second, "10/100 tasks done, 1 failed, 5.0 tasks/s, ETA 18s"},
+		{100, Stats{Done: 100}, 4 * time.Second, "100/100 tasks done, 0 failed, 25.0 tasks/s"},
+	}
+	for _, tt := range tests {
+		p := &Progress{Total: tt.total}
+		if actual := p.format(tt.stats, tt.elapsed); actual != tt.expected {
+			t.Errorf("format(%+v, %v): expected %q, actual %q", tt.stats, tt.elapsed, tt.expected, actual)
+		}
+	}
+}
+
+//TestHooks test OnTaskStart and OnTaskDone are called for every task
+func TestHooks(t *testing.T) {
+	var (
+		mu            sync.Mutex
+		started, done []int
+	)
+	var out bytes.Buffer
+	c := Context{
+		DOP:         3,
+		FactoryFunc: factoryFuncSquares(10, 7, errTest),
+		ErrorPolicy: ContinueOnError,
+		OnTaskStart: func(res TaskResult) {
+			mu.Lock()
+			started = append(started, res.Seq)
+			mu.Unlock()
+		},
+		OnTaskDone: func(res TaskResult) {
+			mu.Lock()
+			done = append(done, res.Seq)
+			mu.Unlock()
+			if (res.Err != nil) != (res.Seq == 7) || res.Duration <= 0 {
+				t.Errorf("OnTaskDone: unexpected result %+v", res)
+			}
+		},
+		Progress: &Progress{Total: 10, Logger: log.New(&out, "", 0)},
+	}
+	Do(&c)
+	if len(started) != 10 || len(done) != 10 {
+		t.Errorf("expected hooks called for 10 tasks, actual %d started %d done", len(started), len(done))
+	}
+
+	//the final progress line is logged before Do returns
+	if !strings.Contains(out.String(), "10/10 tasks done, 1 failed") {
+		t.Errorf("expected final progress, actual %q", out.String())
+	}
+}
@@ -0,0 +1,86 @@
+package workers
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+type (
+	//RateKeyer is implemented by tasks sharing a rate limit with tasks of the same key, such as a host
+	RateKeyer interface {
+		RateKey() string
+	}
+
+	//RateLimit caps how fast tasks start with a token bucket, independently of DOP
+	RateLimit struct {
+		//Rate is the number of tasks allowed to start per second
+		Rate float64
+		//Burst is the number of tasks allowed to start at once, defaults to 1
+		Burst int
+		//PerKey gives tasks of each RateKey a bucket of their own, tasks not RateKeyer share one
+		PerKey bool
+
+		mu      sync.Mutex
+		buckets map[string]*bucket
+	}
+
+	//bucket holds tokens refilled at rate per second up to burst
+	bucket struct {
+		tokens float64
+		last   time.Time
+	}
+)
+
+//wait blocks until t is allowed to start or ctx is done
+func (l *RateLimit) wait(ctx context.Context, clock Clock, t Task) error {
+	if l == nil || l.Rate <= 0 {
+		return nil
+	}
+	key := ""
+	if rk, ok := unwrap(t).(RateKeyer); ok && l.PerKey {
+		key = rk.RateKey()
+	}
+
+	l.mu.Lock()
+	if l.buckets == nil {
+		l.buckets = map[string]*bucket{}
+	}
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(l.burst()), last: clock.Now()}
+		l.buckets[key] = b
+	}
+	delay := b.reserve(clock.Now(), l.Rate, l.burst())
+	l.mu.Unlock()
+
+	if err := clock.Sleep(ctx, delay); err != nil {
+		l.mu.Lock()
+		b.tokens++ //give back the token reserved
+		l.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+func (l *RateLimit) burst() int {
+	if l.Burst < 1 {
+		return 1
+	}
+	return l.Burst
+}
+
+//reserve takes a token at now and returns how long to wait until it is available
+func (b *bucket) reserve(now time.Time, rate float64, burst int) time.Duration {
+	b.tokens += now.Sub(b.last).Seconds() * rate
+	if b.tokens > float64(burst) {
+		b.tokens = float64(burst)
+	}
+	b.last = now
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / rate * float64(time.Second))
+}
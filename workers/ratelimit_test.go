@@ -0,0 +1,137 @@
+package workers
+
+import (
+	"sort"
+	"testing"
+	"time"
+)
+
+//TestBucket test tokens are taken and refilled at rate up to burst
+func TestBucket(t *testing.T) {
+	start := time.Now()
+	b := &bucket{tokens: 2, last: start}
+	tests := []struct {
+		after    time.Duration
+		expected time.Duration
+	}{
+		{0, 0},
+		{0, 0},
+		{0, 100 * time.Millisecond},
+		{0, 200 * time.Millisecond},
+		{time.Second, 0}, //refilled up to burst, not beyond
+		{time.Second, 0},
+		{time.Second, 100 * time.Millisecond},
+	}
+	for i, tt := range tests {
+		actual := b.reserve(start.Add(tt.after), 10, 2)
+		if (actual - tt.expected).Round(time.Millisecond) != 0 {
+			t.Errorf("reserve #%d: expected delay %v, actual %v", i, tt.expected, actual)
+		}
+	}
+}
+
+//host is a probe with a rate key
+type host struct {
+	*probe
+	name string
+}
+
+func (h host) RateKey() string { return h.name }
+
+//TestRateLimit test tasks start no faster than Rate regardless of DOP
+func TestRateLimit(t *testing.T) {
+	tests := []struct {
+		perKey      bool
+		expectedSec float64
+	}{
+		{false, 0.2}, //20 tasks at 100 per second
+		{true, 0.1},  //10 tasks each of 2 hosts at 100 per second
+	}
+	for _, tt := range tests {
+		g, probes := createProbes(20, time.Millisecond)
+		var tasks []Task
+		for i, p := range probes {
+			tasks = append(tasks, host{probe: p.(*probe), name: []string{"a", "b"}[i%2]})
+		}
+		c := Context{
+			DOP:         8,
+			FactoryFunc: factoryFuncOf(tasks...),
+			RateLimit:   &RateLimit{Rate: 100, PerKey: tt.perKey},
+		}
+		start := time.Now()
+		if err := Do(&c); err != nil {
+			t.Fatalf("unexpected err %v", err)
+		}
+		actualSec := time.Since(start).Seconds()
+		if actualSec < tt.expectedSec-0.02 || actualSec > tt.expectedSec+0.1 {
+			t.Errorf("perKey %v: expected tasks to complete in %v, actual %v", tt.perKey, tt.expectedSec, actualSec)
+		}
+		if g.max > c.DOP {
+			t.Errorf("perKey %v: expected at most %d tasks running, actual %d", tt.perKey, c.DOP, g.max)
+		}
+
+		var starts []time.Time
+		for _, p := range probes {
+			starts = append(starts, p.(*probe).start)
+		}
+		sort.Slice(starts, func(i, j int) bool { return starts[i].Before(starts[j]) })
+		if span := starts[len(starts)-1].Sub(starts[0]).Seconds(); span < tt.expectedSec-0.02 {
+			t.Errorf("perKey %v: expected starts spread over %vs, actual %vs", tt.perKey, tt.expectedSec, span)
+		}
+	}
+}
+
+//throttled is a task with a rate key and a cost
+type throttled struct {
+	key  string
+	cost Resources
+}
+
+func (t *throttled) Exec(id WorkerID) error { return nil }
+
+func (t *throttled) RateKey() string { return t.key }
+
+func (t *throttled) Cost() Resources { return t.cost }
+
+//TestRateLimitBudget test a task waiting for a token leaves Budget to tasks allowed to start
+func TestRateLimitBudget(t *testing.T) {
+	epoch := time.Unix(0, 0)
+	clock := NewFakeClock(epoch)
+	all := Resources{Memory: 1}
+	tasks := []Task{&throttled{"a", all}, &throttled{"a", all}, &throttled{"b", all}}
+	done := make(chan struct{}, len(tasks))
+	h := Start(&Context{
+		DOP:         3,
+		Clock:       clock,
+		FactoryFunc: FromSlice(tasks),
+		Budget:      all,
+		RateLimit:   &RateLimit{Rate: 1, PerKey: true},
+		OnTaskDone:  func(TaskResult) { done <- struct{}{} },
+	})
+	for i := 0; i < 2; i++ { //an a and b, while the other a waits for a token
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected tasks allowed to start to execute while a task waits for a token")
+		}
+	}
+	for !clock.Step() { //the other a may be yet to ask for its token
+		time.Sleep(time.Millisecond)
+	}
+	report, err := h.Wait()
+	if err != nil {
+		t.Fatalf("unexpected err %v", err)
+	}
+	var late []Task
+	for _, res := range report.Results {
+		if !res.Start.Equal(epoch) {
+			late = append(late, res.Task)
+		}
+		if !res.Start.Equal(epoch) && !res.Start.Equal(epoch.Add(time.Second)) {
+			t.Errorf("expected %v to start at 0s or 1s, actual %v", res.Task, res.Start.Sub(epoch))
+		}
+	}
+	if len(late) != 1 || late[0].(*throttled).key != "a" {
+		t.Errorf("expected an a only to wait for its token, actual %v", late)
+	}
+}
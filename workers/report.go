@@ -0,0 +1,160 @@
+package workers
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+type (
+	//Status is the outcome of a task execution
+	Status int
+
+	//ResultTask is a Task which yields a value once executed
+	ResultTask interface {
+		Task
+		Result() interface{}
+	}
+
+	//TaskResult records the execution of a single task
+	TaskResult struct {
+		Task     Task
+		Seq      int //order in which the task was made by FactoryFunc
+		WorkerID WorkerID
+		Status   Status
+		Start    time.Time
+		Duration time.Duration
+		Attempts int         //number of executions, more than 1 if the task was retried
+		Value    interface{} //set when Task is a ResultTask
+		Err      error
+	}
+
+	//Report collects results of all tasks executed by DoReport
+	Report struct {
+		Results []TaskResult
+
+		mu sync.Mutex
+	}
+)
+
+const (
+	//Succeeded means Exec returned no error
+	Succeeded Status = iota
+	//Failed means Exec returned an error
+	Failed
+	//TimedOut means Exec did not return within the task timeout
+	TimedOut
+	//Panicked means Exec panicked, Err is a *PanicError
+	Panicked
+	//Skipped means the task was not executed as a Journal recorded it completed by an earlier run
+	Skipped
+	//Blocked means the task was not executed as a task it depends on did not succeed, Err is a *DependencyError
+	Blocked
+	//Cancelled means the task was handed to a worker but not executed as the run was cancelled, Err is the error of the context
+	Cancelled
+)
+
+func (s Status) String() string {
+	switch s {
+	case Succeeded:
+		return "succeeded"
+	case Failed:
+		return "failed"
+	case TimedOut:
+		return "timed out"
+	case Panicked:
+		return "panicked"
+	case Skipped:
+		return "skipped"
+	case Blocked:
+		return "blocked"
+	case Cancelled:
+		return "cancelled"
+	}
+	return fmt.Sprintf("Status(%d)", int(s))
+}
+
+//add records a result, it is safe for concurrent use
+func (r *Report) add(res TaskResult) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.Results = append(r.Results, res)
+	r.mu.Unlock()
+}
+
+//sort orders results as tasks were made by FactoryFunc
+func (r *Report) sort() {
+	if r == nil {
+		return
+	}
+	sort.Slice(r.Results, func(i, j int) bool { return r.Results[i].Seq < r.Results[j].Seq })
+}
+
+//Failed returns results of tasks executed which did not succeed
+func (r *Report) Failed() []TaskResult {
+	failed := []TaskResult{}
+	for _, res := range r.Results {
+		if res.Status != Succeeded && res.Status != Skipped && res.Status != Blocked && res.Status != Cancelled {
+			failed = append(failed, res)
+		}
+	}
+	return failed
+}
+
+//TimedOut returns results of tasks which did not finish within their timeout
+func (r *Report) TimedOut() []TaskResult {
+	timedOut := []TaskResult{}
+	for _, res := range r.Results {
+		if res.Status == TimedOut {
+			timedOut = append(timedOut, res)
+		}
+	}
+	return timedOut
+}
+
+//Skipped returns results of tasks completed by an earlier run
+func (r *Report) Skipped() []TaskResult {
+	skipped := []TaskResult{}
+	for _, res := range r.Results {
+		if res.Status == Skipped {
+			skipped = append(skipped, res)
+		}
+	}
+	return skipped
+}
+
+//Blocked returns results of tasks not executed as a task they depend on did not succeed
+func (r *Report) Blocked() []TaskResult {
+	blocked := []TaskResult{}
+	for _, res := range r.Results {
+		if res.Status == Blocked {
+			blocked = append(blocked, res)
+		}
+	}
+	return blocked
+}
+
+//Cancelled returns results of tasks handed to a worker but not executed as the run was cancelled
+func (r *Report) Cancelled() []TaskResult {
+	cancelled := []TaskResult{}
+	for _, res := range r.Results {
+		if res.Status == Cancelled {
+			cancelled = append(cancelled, res)
+		}
+	}
+	return cancelled
+}
+
+//Values returns values yielded by succeeded ResultTasks
+func (r *Report) Values() []interface{} {
+	values := []interface{}{}
+	for _, res := range r.Results {
+		if res.Status == Succeeded && res.Value != nil {
+			values = append(values, res.Value)
+		}
+	}
+	return values
+}
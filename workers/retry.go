@@ -0,0 +1,64 @@
+package workers
+
+import (
+	"math"
+	"math/rand"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+//RetryPolicy controls how failed tasks are executed again
+type RetryPolicy struct {
+	//MaxAttempts is the number of executions including the first one, <= 1 means no retry
+	MaxAttempts int
+	//Backoff is the delay before the second attempt
+	Backoff time.Duration
+	//MaxBackoff caps the delay between attempts, 0 means no cap other than the longest Duration
+	MaxBackoff time.Duration
+	//Multiplier grows the delay after each attempt, defaults to 2
+	Multiplier float64
+	//Jitter randomizes each delay by up to this fraction, must be within [0, 1]
+	Jitter float64
+	//Retryable decides whether an error is worth another attempt, nil means any error is
+	Retryable func(error) bool
+}
+
+//retry tells if a task should be executed again after its attempt-th execution failed with err
+func (p *RetryPolicy) retry(attempt int, err error) bool {
+	if p == nil || attempt >= p.MaxAttempts {
+		return false
+	}
+	return p.Retryable == nil || p.Retryable(err)
+}
+
+//backoff returns the delay after the attempt-th execution
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 2
+	}
+	limit := float64(math.MaxInt64) //without MaxBackoff, d must still fit in a Duration
+	if p.MaxBackoff > 0 {
+		limit = float64(p.MaxBackoff)
+	}
+	d := float64(p.Backoff)
+	for i := 1; i < attempt && d <= limit; i++ {
+		d *= multiplier
+	}
+	if d > limit {
+		d = limit
+	}
+	if p.Jitter > 0 {
+		d += d * p.Jitter * (2*rand.Float64() - 1)
+	}
+	if d >= float64(math.MaxInt64) {
+		return math.MaxInt64
+	}
+	return time.Duration(d)
+}
+
+//wait sleeps before the next attempt, it returns false if ctx is done first
+func (p *RetryPolicy) wait(ctx context.Context, clock Clock, attempt int) bool {
+	return clock.Sleep(ctx, p.backoff(attempt)) == nil
+}
@@ -0,0 +1,266 @@
+package workers
+
+import (
+	"container/heap"
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+type (
+	//Prioritized is implemented by tasks carrying a scheduling hint such as their cost,
+	//a Scheduler starts tasks with higher priority first
+	Prioritized interface {
+		Priority() int64
+	}
+
+	//Weighted is implemented by tasks which occupy more than one of DOP slots while executed,
+	//weights above DOP are capped at DOP
+	Weighted interface {
+		Weight() int
+	}
+
+	//Resources is an amount of memory and CPU, taken by a task while executed or allowed by Context.Budget
+	Resources struct {
+		Memory int64 //bytes
+		CPU    int64 //units of the caller's choice, such as millicores
+	}
+
+	//Coster is implemented by tasks which declare the resources they take while executed,
+	//a cost above Context.Budget is capped at Budget so that the task executes alone
+	Coster interface {
+		Cost() Resources
+	}
+
+	//Scheduler orders tasks made by FactoryFunc before handing them to workers
+	Scheduler struct {
+		//Lookahead is the number of tasks made ahead to be ordered, 0 means all tasks
+		Lookahead int
+		//Less reports whether a should start before b, nil means higher Priority first
+		Less func(a, b Task) bool
+	}
+
+	//queue is a heap of jobs ordered by a Scheduler, ties are broken by seq
+	queue struct {
+		jobs []job
+		less func(a, b Task) bool
+	}
+
+	//amount is what a task takes while executed, DOP slots and resources
+	amount struct {
+		slots int
+		Resources
+	}
+
+	//slots is a FIFO semaphore of DOP slots, whose size follows DOP, and of resources of Context.Budget
+	slots struct {
+		mu      sync.Mutex
+		size    int
+		budget  Resources //a zero field means no limit
+		used    amount
+		waiters []*waiter
+	}
+
+	waiter struct {
+		n     amount
+		ready chan struct{}
+	}
+)
+
+//byPriority starts tasks with higher Priority first, tasks not Prioritized have priority 0
+func byPriority(a, b Task) bool {
+	return priority(a) > priority(b)
+}
+
+func priority(t Task) int64 {
+	if p, ok := unwrap(t).(Prioritized); ok {
+		return p.Priority()
+	}
+	return 0
+}
+
+func weight(t Task) int {
+	if w, ok := unwrap(t).(Weighted); ok && w.Weight() > 1 {
+		return w.Weight()
+	}
+	return 1
+}
+
+//cost returns what t takes while executed
+func cost(t Task) amount {
+	n := amount{slots: weight(t)}
+	if c, ok := unwrap(t).(Coster); ok {
+		n.Resources = c.Cost()
+	}
+	return n
+}
+
+//generate sends tasks made by FactoryFunc to workers in the order of s until there are
+//no more tasks, the run is drained or cancelled
+func (s *Scheduler) generate(h *Handle) error {
+	q := &queue{less: s.Less}
+	if q.less == nil {
+		q.less = byPriority
+	}
+	defer close(h.tasks)
+	made := false
+	for seq := 0; ; {
+		for !made && (s.Lookahead <= 0 || q.Len() < s.Lookahead) {
+			task, err := h.make()
+			if err != nil {
+				h.factoryErr = err //read after g.Wait returns
+				return err
+			}
+			if task == nil {
+				made = true
+				break
+			}
+			heap.Push(q, job{seq: seq, made: h.c.clock().Now(), Task: task})
+			seq++
+		}
+		if q.Len() == 0 {
+			return nil
+		}
+		if h.c.draining() {
+			h.drainErr = ErrDrained
+			return nil
+		}
+		select {
+		case h.tasks <- q.jobs[0]:
+			heap.Pop(q)
+		case <-h.c.drain():
+			h.drainErr = ErrDrained
+			return nil
+		case <-h.ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (q *queue) Len() int { return len(q.jobs) }
+
+func (q *queue) Less(i, j int) bool {
+	a, b := q.jobs[i], q.jobs[j]
+	if q.less(a.Task, b.Task) {
+		return true
+	}
+	if q.less(b.Task, a.Task) {
+		return false
+	}
+	return a.seq < b.seq
+}
+
+func (q *queue) Swap(i, j int) { q.jobs[i], q.jobs[j] = q.jobs[j], q.jobs[i] }
+
+func (q *queue) Push(x interface{}) { q.jobs = append(q.jobs, x.(job)) }
+
+func (q *queue) Pop() interface{} {
+	last := len(q.jobs) - 1
+	j := q.jobs[last]
+	q.jobs = q.jobs[:last]
+	return j
+}
+
+//acquire takes n, waiting behind earlier callers until it is free or ctx is done.
+//It returns the amount taken, which is less than n where n exceeds size or budget.
+func (s *slots) acquire(ctx context.Context, n amount) (amount, error) {
+	s.mu.Lock()
+	n = s.capped(n)
+	if len(s.waiters) == 0 && s.fits(n) {
+		s.used = s.used.add(n, 1)
+		s.mu.Unlock()
+		return n, nil
+	}
+	w := &waiter{n: n, ready: make(chan struct{})}
+	s.waiters = append(s.waiters, w)
+	s.mu.Unlock()
+
+	defer park(ctx, func() bool { return closed(w.ready) || ctx.Err() != nil })()
+	select {
+	case <-w.ready:
+		return w.n, nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		select {
+		case <-w.ready: //granted while cancelled
+			s.used = s.used.add(w.n, -1)
+			s.grant()
+		default:
+			for i, x := range s.waiters {
+				if x == w {
+					s.waiters = append(s.waiters[:i], s.waiters[i+1:]...)
+					break
+				}
+			}
+			s.grant()
+		}
+		return amount{}, ctx.Err()
+	}
+}
+
+//release gives back n
+func (s *slots) release(n amount) {
+	s.mu.Lock()
+	s.used = s.used.add(n, -1)
+	s.grant()
+	s.mu.Unlock()
+}
+
+//resize changes the number of slots, slots in use above a smaller size are given back as they are released
+func (s *slots) resize(size int) {
+	s.mu.Lock()
+	s.size = size
+	s.grant()
+	s.mu.Unlock()
+}
+
+//grant hands what is free to waiters in order, s.mu must be held
+func (s *slots) grant() {
+	for len(s.waiters) > 0 {
+		w := s.waiters[0]
+		w.n = s.capped(w.n)
+		if !s.fits(w.n) {
+			return
+		}
+		s.used = s.used.add(w.n, 1)
+		close(w.ready)
+		s.waiters = s.waiters[1:]
+	}
+}
+
+//capped returns n within size and budget, s.mu must be held
+func (s *slots) capped(n amount) amount {
+	if n.slots > s.size {
+		n.slots = s.size
+	}
+	n.Memory = capResource(n.Memory, s.budget.Memory)
+	n.CPU = capResource(n.CPU, s.budget.CPU)
+	return n
+}
+
+//fits tells if n is free, s.mu must be held
+func (s *slots) fits(n amount) bool {
+	return s.used.slots+n.slots <= s.size &&
+		s.used.Memory+n.Memory <= s.budget.Memory &&
+		s.used.CPU+n.CPU <= s.budget.CPU
+}
+
+//capResource returns n within budget, 0 if there is no budget for it
+func capResource(n, budget int64) int64 {
+	if budget <= 0 || n < 0 {
+		return 0
+	}
+	if n > budget {
+		return budget
+	}
+	return n
+}
+
+//add returns a plus sign times b
+func (a amount) add(b amount, sign int) amount {
+	a.slots += sign * b.slots
+	a.Memory += int64(sign) * b.Memory
+	a.CPU += int64(sign) * b.CPU
+	return a
+}
@@ -0,0 +1,185 @@
+package workers
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+//sized is a Task with a size as its priority
+type sized struct {
+	size int64
+}
+
+//sized implements Task and Prioritized
+func (s sized) Exec(id WorkerID) error { return nil }
+
+func (s sized) Priority() int64 { return s.size }
+
+//TestScheduler test tasks start in order of priority
+func TestScheduler(t *testing.T) {
+	tests := []struct {
+		sizes     []int64
+		lookahead int
+		expected  []int64
+	}{
+		{[]int64{1, 5, 3, 10, 5}, 0, []int64{10, 5, 5, 3, 1}},
+		{[]int64{1, 5, 3, 10, 5}, 2, []int64{5, 3, 10, 5, 1}},
+	}
+	for _, tt := range tests {
+		var tasks []Task
+		for _, size := range tt.sizes {
+			tasks = append(tasks, sized{size})
+		}
+		var started []int64
+		c := Context{
+			DOP:         1,
+			FactoryFunc: factoryFuncOf(tasks...),
+			Scheduler:   &Scheduler{Lookahead: tt.lookahead},
+			OnTaskStart: func(res TaskResult) { started = append(started, res.Task.(sized).size) },
+		}
+		report, err := DoReport(&c)
+		if err != nil {
+			t.Fatalf("unexpected err %v", err)
+		}
+		for i := range tt.expected {
+			if started[i] != tt.expected[i] {
+				t.Errorf("lookahead %d: expected order %v, actual %v", tt.lookahead, tt.expected, started)
+				break
+			}
+		}
+		//Seq records the order of FactoryFunc, not of execution
+		for i, res := range report.Results {
+			if res.Seq != i || res.Task.(sized).size != tt.sizes[i] {
+				t.Errorf("expected result %d of size %d, actual %+v", i, tt.sizes[i], res)
+			}
+		}
+	}
+}
+
+//heavy is a probe occupying several DOP slots
+type heavy struct {
+	*probe
+	weight int
+}
+
+func (h heavy) Weight() int { return h.weight }
+
+//TestWeighted test a Weighted task keeps other tasks from running beyond DOP
+func TestWeighted(t *testing.T) {
+	tests := []struct {
+		weight         int
+		expectedActive int
+	}{
+		{4, 1},
+		{3, 2},
+		{10, 1}, //capped at DOP
+	}
+	for _, tt := range tests {
+		g, probes := createProbes(20, 5*time.Millisecond)
+		h := heavy{probe: &probe{g: g, d: 30 * time.Millisecond}, weight: tt.weight}
+		tasks := append([]Task{h}, probes...)
+		c := Context{DOP: 4, FactoryFunc: factoryFuncOf(tasks...)}
+		if err := Do(&c); err != nil {
+			t.Fatalf("unexpected err %v", err)
+		}
+		if g.max > 4 {
+			t.Errorf("weight %d: expected at most 4 tasks running, actual %d", tt.weight, g.max)
+		}
+		//tasks started while heavy ran
+		for _, p := range probes {
+			p := p.(*probe)
+			if p.start.After(h.start) && p.start.Before(h.start.Add(h.d)) && p.active > tt.expectedActive {
+				t.Errorf("weight %d: expected at most %d tasks running along with heavy, actual %d", tt.weight, tt.expectedActive, p.active)
+			}
+		}
+	}
+}
+
+//costly is a probe taking resources
+type costly struct {
+	*probe
+	cost Resources
+}
+
+func (c costly) Cost() Resources { return c.cost }
+
+//TestBudget test tasks start only while the resources they take fit in Budget
+func TestBudget(t *testing.T) {
+	tests := []struct {
+		name           string
+		budget         Resources
+		costs          []Resources
+		expectedActive int
+	}{
+		{"memory", Resources{Memory: 100}, []Resources{{Memory: 40}, {Memory: 40}, {Memory: 40}}, 2},
+		{"cpu", Resources{CPU: 2000}, []Resources{{CPU: 1500}, {CPU: 500}}, 2},
+		{"memory bound", Resources{Memory: 100, CPU: 3}, []Resources{{Memory: 50, CPU: 1}}, 2},
+		{"cpu bound", Resources{Memory: 100, CPU: 3}, []Resources{{Memory: 10, CPU: 1}}, 3},
+		{"capped", Resources{Memory: 100}, []Resources{{Memory: 1000}}, 1},
+		{"no budget", Resources{}, []Resources{{Memory: 1000, CPU: 1000}}, 8},
+		{"no cost", Resources{Memory: 1}, []Resources{{}}, 8},
+	}
+	for _, tt := range tests {
+		g := &gauge{}
+		tasks := []Task{}
+		for i := 0; i < 24; i++ {
+			tasks = append(tasks, costly{probe: &probe{g: g, d: 2 * time.Millisecond}, cost: tt.costs[i%len(tt.costs)]})
+		}
+		if err := Do(&Context{DOP: 8, FactoryFunc: FromSlice(tasks), Budget: tt.budget}); err != nil {
+			t.Fatalf("%s: unexpected err %v", tt.name, err)
+		}
+		if g.max > tt.expectedActive {
+			t.Errorf("%s: expected at most %d tasks running, actual %d", tt.name, tt.expectedActive, g.max)
+		}
+	}
+}
+
+//TestSlots test slots are granted in FIFO order and follow resizes
+func TestSlots(t *testing.T) {
+	s := &slots{size: 2}
+	if n, _ := s.acquire(context.Background(), amount{slots: 1}); n.slots != 1 {
+		t.Fatalf("expected 1 slot, actual %d", n.slots)
+	}
+	var (
+		mu    sync.Mutex
+		order []int
+		wg    sync.WaitGroup
+	)
+	for i, n := range []int{2, 1} {
+		wg.Add(1)
+		go func(i, n int) {
+			defer wg.Done()
+			got, err := s.acquire(context.Background(), amount{slots: n})
+			mu.Lock()
+			order = append(order, i)
+			mu.Unlock()
+			if err == nil {
+				s.release(got)
+			}
+		}(i, n)
+		time.Sleep(5 * time.Millisecond) //queue waiters in order
+	}
+	s.release(amount{slots: 1})
+	wg.Wait()
+	if len(order) != 2 || order[0] != 0 {
+		t.Errorf("expected waiters granted in order [0 1], actual %v", order)
+	}
+
+	//a waiter for more slots than size gets size slots
+	s.resize(1)
+	if n, _ := s.acquire(context.Background(), amount{slots: 3}); n.slots != 1 {
+		t.Errorf("expected 1 slot after resize, actual %d", n.slots)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+	if _, err := s.acquire(ctx, amount{slots: 1}); err != context.DeadlineExceeded {
+		t.Errorf("expected err %v, actual err %v", context.DeadlineExceeded, err)
+	}
+	if len(s.waiters) != 0 {
+		t.Errorf("expected cancelled waiter removed, actual %d waiters", len(s.waiters))
+	}
+}
@@ -0,0 +1,91 @@
+package workers
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+//Cleaner is implemented by tasks which leave partial output behind when they do not succeed,
+//such as a half-written file
+type Cleaner interface {
+	//Cleanup is called by the worker once the task failed for good, err is the error of its last attempt.
+	//A task abandoned on timeout may still be running.
+	Cleanup(err error)
+}
+
+//ErrDrained is returned once a run stopped handing out tasks as Context.Drain was done
+var ErrDrained = errors.New("drained before all tasks were executed")
+
+//NotifyShutdown ties a run to OS signals, SIGINT and SIGTERM if no signal is given.
+//drain is done on the first signal, it stops handing out tasks while tasks in flight finish;
+//ctx is done on the second signal, or once timeout elapsed after the first, 0 means no timeout.
+//stop unregisters the signals and cancels both contexts.
+func NotifyShutdown(parent context.Context, timeout time.Duration, sigs ...os.Signal) (drain, ctx context.Context, stop func()) {
+	if len(sigs) == 0 {
+		sigs = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	}
+	drain, drained := context.WithCancel(parent)
+	ctx, cancel := context.WithCancel(parent)
+	ch := make(chan os.Signal, 2)
+	signal.Notify(ch, sigs...)
+
+	go func() {
+		select {
+		case <-ch:
+			drained()
+		case <-ctx.Done():
+			return
+		}
+		var deadline <-chan time.Time
+		if timeout > 0 {
+			timer := time.NewTimer(timeout)
+			defer timer.Stop()
+			deadline = timer.C
+		}
+		select {
+		case <-ch:
+		case <-deadline:
+		case <-ctx.Done():
+		}
+		cancel()
+	}()
+
+	var once sync.Once
+	stop = func() {
+		once.Do(func() {
+			signal.Stop(ch)
+			drained()
+			cancel()
+		})
+	}
+	return drain, ctx, stop
+}
+
+//drain returns a channel closed once c.Drain is done, nil if c has no Drain
+func (c *Context) drain() <-chan struct{} {
+	if c.Drain == nil {
+		return nil
+	}
+	return c.Drain.Done()
+}
+
+//draining tells if c.Drain is done
+func (c *Context) draining() bool {
+	return c.Drain != nil && c.Drain.Err() != nil
+}
+
+//cleanup lets a task, or an item of a stage, remove its partial output once it failed
+func cleanup(v interface{}, err error) {
+	if t, ok := v.(Task); ok {
+		v = unwrap(t)
+	}
+	if cl, ok := v.(Cleaner); ok {
+		cl.Cleanup(err)
+	}
+}
@@ -0,0 +1,220 @@
+//go:build !windows
+
+package workers
+
+import (
+	"errors"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+//cleaned is a task recording the error it cleans up after
+type cleaned struct {
+	fail    error
+	started chan struct{} //closed once started, the task then blocks until cancelled, nil means no blocking
+	cleaned error
+}
+
+func (c *cleaned) Exec(id WorkerID) error {
+	return c.ExecContext(context.Background(), id)
+}
+
+func (c *cleaned) ExecContext(ctx context.Context, id WorkerID) error {
+	if c.fail != nil {
+		return c.fail
+	}
+	if c.started != nil {
+		close(c.started)
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	return nil
+}
+
+func (c *cleaned) Cleanup(err error) {
+	c.cleaned = err
+}
+
+func signalSelf(t *testing.T, sig os.Signal) {
+	p, err := os.FindProcess(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Signal(sig); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func waitDone(t *testing.T, what string, ctx context.Context) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected %s done", what)
+	}
+}
+
+func TestNotifyShutdown(t *testing.T) {
+	//second signal cancels hard
+	drain, ctx, stop := NotifyShutdown(context.Background(), 0, syscall.SIGUSR1)
+	signalSelf(t, syscall.SIGUSR1)
+	waitDone(t, "drain", drain)
+	if ctx.Err() != nil {
+		t.Errorf("expected ctx not done after the first signal")
+	}
+	signalSelf(t, syscall.SIGUSR1)
+	waitDone(t, "ctx", ctx)
+	stop()
+
+	//drain timeout cancels hard
+	drain, ctx, stop = NotifyShutdown(context.Background(), 20*time.Millisecond, syscall.SIGUSR1)
+	defer stop()
+	start := time.Now()
+	signalSelf(t, syscall.SIGUSR1)
+	waitDone(t, "drain", drain)
+	waitDone(t, "ctx", ctx)
+	if d := time.Since(start); d < 20*time.Millisecond {
+		t.Errorf("expected ctx done after the drain timeout, actual %v", d)
+	}
+}
+
+//TestDrain test tasks handed out before drain finish and no task starts after
+func TestDrain(t *testing.T) {
+	for _, scheduler := range []*Scheduler{nil, {Lookahead: 4}} {
+		drain, drained := context.WithCancel(context.Background())
+		var started int32
+		_, probes := createProbes(100, time.Millisecond)
+		report, err := DoReport(&Context{
+			DOP:         2,
+			FactoryFunc: FromSlice(probes),
+			Scheduler:   scheduler,
+			Drain:       drain,
+			OnTaskStart: func(TaskResult) {
+				if atomic.AddInt32(&started, 1) == 10 {
+					drained()
+				}
+			},
+		})
+		if err != ErrDrained {
+			t.Errorf("expected %v, actual %v", ErrDrained, err)
+		}
+		//tasks already handed to a worker when drain is done still execute
+		if n := len(report.Results); n < 10 || n > 12 {
+			t.Errorf("expected 10 to 12 tasks executed, actual %d", n)
+		}
+		if len(report.Failed()) != 0 {
+			t.Errorf("expected tasks in flight to succeed, actual %v", report.Failed())
+		}
+	}
+}
+
+//TestCleaner test a failed task cleans up, be it failed on its own or cancelled
+func TestCleaner(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	failed := &cleaned{fail: errTest}
+	blocked := &cleaned{started: make(chan struct{})}
+	done := &cleaned{}
+	var reported int32
+	Do(&Context{
+		Context:     ctx,
+		DOP:         3,
+		FactoryFunc: FromSlice([]Task{failed, blocked, done}),
+		ErrorPolicy: ContinueOnError,
+		OnTaskDone: func(TaskResult) {
+			if atomic.AddInt32(&reported, 1) == 2 { //failed and done, blocked only returns once cancelled
+				go func() {
+					<-blocked.started
+					cancel()
+				}()
+			}
+		},
+	})
+	if failed.cleaned != errTest {
+		t.Errorf("expected failed task cleaned up after %v, actual %v", errTest, failed.cleaned)
+	}
+	if blocked.cleaned != context.Canceled {
+		t.Errorf("expected cancelled task cleaned up after %v, actual %v", context.Canceled, blocked.cleaned)
+	}
+	if done.cleaned != nil {
+		t.Errorf("expected succeeded task not cleaned up, actual %v", done.cleaned)
+	}
+}
+
+//TestCancelled test a task handed to a worker but cancelled before it starts is reported and cleaned up
+func TestCancelled(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	ctx, cancel := context.WithCancel(context.Background())
+	first, second := &cleaned{}, &cleaned{}
+	go func() {
+		for clock.Sleepers() == 0 { //second waits for a token
+			time.Sleep(time.Millisecond)
+		}
+		cancel()
+	}()
+	report, err := DoReport(&Context{
+		Context:     ctx,
+		DOP:         2,
+		Clock:       clock,
+		FactoryFunc: FromSlice([]Task{first, second}),
+		RateLimit:   &RateLimit{Rate: 1},
+		ErrorPolicy: ContinueOnError,
+	})
+	if err != context.Canceled {
+		t.Errorf("expected %v, actual %v", context.Canceled, err)
+	}
+	//either task may be the one left waiting for the token
+	cancelled := report.Cancelled()
+	if len(cancelled) != 1 || cancelled[0].Err != context.Canceled {
+		t.Fatalf("expected a task cancelled, actual %+v", cancelled)
+	}
+	if len(report.Results) != 2 || len(report.Failed()) != 0 {
+		t.Errorf("expected the other task succeeded, actual %+v", report.Results)
+	}
+	for _, c := range []*cleaned{first, second} {
+		var expected error
+		if cancelled[0].Task == Task(c) {
+			expected = context.Canceled
+		}
+		if c.cleaned != expected {
+			t.Errorf("expected task cleaned up after %v, actual %v", expected, c.cleaned)
+		}
+	}
+}
+
+//TestPipelineDrain test items emitted before drain go through all stages
+func TestPipelineDrain(t *testing.T) {
+	drain, drained := context.WithCancel(context.Background())
+	var emitted, summed int64
+	p := &Pipeline{
+		Drain: drain,
+		Source: func(ctx context.Context, emit func(interface{}) error) error {
+			for i := 0; ; i++ {
+				if i == 20 {
+					drained()
+				}
+				if err := emit(i); err != nil {
+					return err
+				}
+				emitted++
+			}
+		},
+		Stages: []*Stage{
+			{Name: "square", Func: squareStage(-1), Workers: Context{DOP: 2}},
+			{Name: "sum", Func: func(ctx context.Context, item interface{}, emit func(interface{}) error) error {
+				time.Sleep(time.Millisecond)
+				atomic.AddInt64(&summed, 1)
+				return nil
+			}},
+		},
+	}
+	if _, err := p.Run(); !errors.Is(err, ErrDrained) {
+		t.Errorf("expected %v, actual %v", ErrDrained, err)
+	}
+	if emitted != 20 || summed != 20 {
+		t.Errorf("expected 20 items emitted and summed, actual %d emitted, %d summed", emitted, summed)
+	}
+}
@@ -0,0 +1,81 @@
+package workers
+
+import (
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+//Stream is a task source fed by a producer while tasks are being executed,
+//e.g. a directory walk sending a task for each file it finds
+type Stream struct {
+	tasks chan Task
+
+	mu   sync.Mutex
+	err  error
+	done bool
+}
+
+//FromSlice returns a FactoryFunc making tasks in order, it is safe for concurrent use
+func FromSlice(tasks []Task) FactoryFunc {
+	var (
+		mu    sync.Mutex
+		index int
+	)
+	return func() (Task, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if index == len(tasks) {
+			return nil, nil
+		}
+		index++
+		return tasks[index-1], nil
+	}
+}
+
+//FromChan returns a FactoryFunc making tasks received from ch until ch is closed,
+//it is safe for concurrent use
+func FromChan(ch <-chan Task) FactoryFunc {
+	return func() (Task, error) {
+		return <-ch, nil
+	}
+}
+
+//NewStream returns a Stream buffering up to size tasks
+func NewStream(size int) *Stream {
+	return &Stream{tasks: make(chan Task, size)}
+}
+
+//Send hands t to workers, it blocks while the buffer is full until ctx is done
+func (s *Stream) Send(ctx context.Context, t Task) error {
+	select {
+	case s.tasks <- t:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+//Close tells workers there are no more tasks, a non-nil err stops the run and is returned by Do.
+//Send must not be called after Close.
+func (s *Stream) Close(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.done {
+		return
+	}
+	s.err, s.done = err, true
+	close(s.tasks)
+}
+
+//FactoryFunc returns a FactoryFunc making tasks sent to s, it is safe for concurrent use
+func (s *Stream) FactoryFunc() FactoryFunc {
+	return func() (Task, error) {
+		if t, ok := <-s.tasks; ok {
+			return t, nil
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return nil, s.err
+	}
+}
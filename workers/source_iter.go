@@ -0,0 +1,30 @@
+//go:build go1.23
+
+package workers
+
+import (
+	"iter"
+	"sync"
+)
+
+//FromSeq returns a FactoryFunc making tasks yielded by seq, it is safe for concurrent use.
+//The caller must call stop once the run is done if it may end before seq is exhausted.
+func FromSeq(seq iter.Seq[Task]) (f FactoryFunc, stop func()) {
+	var mu sync.Mutex
+	next, pullStop := iter.Pull(seq)
+	f = func() (Task, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		t, ok := next()
+		if !ok {
+			return nil, nil
+		}
+		return t, nil
+	}
+	stop = func() {
+		mu.Lock()
+		defer mu.Unlock()
+		pullStop()
+	}
+	return f, stop
+}
@@ -0,0 +1,45 @@
+//go:build go1.23
+
+package workers
+
+import (
+	"iter"
+	"testing"
+)
+
+//squares yields n squares, the one at errAt fails
+func squares(n int, errAt int) iter.Seq[Task] {
+	return func(yield func(Task) bool) {
+		for i := 0; i < n; i++ {
+			s := &square{n: i}
+			if i == errAt {
+				s.err = errTest
+			}
+			if !yield(s) {
+				return
+			}
+		}
+	}
+}
+
+//TestFromSeq test tasks yielded by an iterator are executed
+func TestFromSeq(t *testing.T) {
+	f, stop := FromSeq(squares(10, -1))
+	defer stop()
+	report, err := DoReport(&Context{DOP: 3, FactoryFunc: f})
+	if err != nil || len(report.Values()) != 10 {
+		t.Fatalf("expected 10 tasks executed, actual %d err %v", len(report.Values()), err)
+	}
+	for i, v := range report.Values() {
+		if v.(int) != i*i {
+			t.Errorf("expected value %d at %d, actual %v", i*i, i, v)
+		}
+	}
+
+	//a run stopped early leaves the iterator to stop
+	f, stop = FromSeq(squares(10, 0))
+	if err := Do(&Context{DOP: 1, FactoryFunc: f}); err != errTest {
+		t.Errorf("expected err %v, actual err %v", errTest, err)
+	}
+	stop()
+}
@@ -0,0 +1,137 @@
+package workers
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+//TestFromSlice test concurrent calls make every task once
+func TestFromSlice(t *testing.T) {
+	tasks := []Task{}
+	for i := 0; i < 1000; i++ {
+		tasks = append(tasks, &square{n: i})
+	}
+	f := FromSlice(tasks)
+
+	var (
+		mu   sync.Mutex
+		made = map[Task]int{}
+		wg   sync.WaitGroup
+	)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				task, err := f()
+				if task == nil || err != nil {
+					return
+				}
+				mu.Lock()
+				made[task]++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if len(made) != len(tasks) {
+		t.Errorf("expected %d tasks made, actual %d", len(tasks), len(made))
+	}
+	for task, n := range made {
+		if n != 1 {
+			t.Errorf("expected task %v made once, actual %d", task, n)
+		}
+	}
+}
+
+//TestFromChan test tasks are made until the channel is closed
+func TestFromChan(t *testing.T) {
+	ch := make(chan Task)
+	go func() {
+		for i := 0; i < 10; i++ {
+			ch <- &square{n: i}
+		}
+		close(ch)
+	}()
+	report, err := DoReport(&Context{DOP: 3, FactoryFunc: FromChan(ch)})
+	if err != nil || len(report.Values()) != 10 {
+		t.Errorf("expected 10 tasks executed, actual %d err %v", len(report.Values()), err)
+	}
+}
+
+//TestStream test tasks are executed while the producer is still sending
+func TestStream(t *testing.T) {
+	s := NewStream(0)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	h := Start(&Context{Context: ctx, DOP: 2, FactoryFunc: s.FactoryFunc()})
+
+	for i := 0; i < 5; i++ {
+		if err := s.Send(ctx, &square{n: i}); err != nil {
+			t.Fatalf("Send: unexpected err %v", err)
+		}
+	}
+	//tasks sent so far are executed before the stream is closed
+	deadline := time.Now().Add(time.Second)
+	for h.Stats().Done < 4 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if done := h.Stats().Done; done < 4 {
+		t.Errorf("expected tasks executed while streaming, actual %d", done)
+	}
+	s.Close(nil)
+	report, err := h.Wait()
+	if err != nil || len(report.Values()) != 5 {
+		t.Errorf("expected 5 tasks executed, actual %d err %v", len(report.Values()), err)
+	}
+}
+
+//TestStreamError test a stream closed with an error stops the run and unblocks the producer
+func TestStreamError(t *testing.T) {
+	errWalk := errors.New("walk failed")
+	s := NewStream(1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s.Send(ctx, &square{n: 1})
+	s.Close(errWalk)
+	if err := Do(&Context{Context: ctx, DOP: 2, FactoryFunc: s.FactoryFunc()}); err != errWalk {
+		t.Errorf("expected err %v, actual err %v", errWalk, err)
+	}
+
+	//a producer blocked on a full stream gives up once ctx is done
+	s = NewStream(0)
+	cancel()
+	if err := s.Send(ctx, &square{}); err != context.Canceled {
+		t.Errorf("expected err %v, actual err %v", context.Canceled, err)
+	}
+}
+
+//TestSourceCancel test a run stops once cancelled while its source waits for tasks
+func TestSourceCancel(t *testing.T) {
+	tests := []struct {
+		name string
+		f    FactoryFunc
+	}{
+		{"channel", FromChan(make(chan Task))},
+		{"stream", NewStream(1).FactoryFunc()},
+	}
+	for _, tt := range tests {
+		ctx, cancel := context.WithCancel(context.Background())
+		errc := make(chan error, 1)
+		go func() { errc <- Do(&Context{Context: ctx, DOP: 2, FactoryFunc: tt.f}) }()
+		cancel()
+		select {
+		case err := <-errc:
+			if err != context.Canceled {
+				t.Errorf("%s: expected err %v, actual err %v", tt.name, context.Canceled, err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: run still waiting for the source after cancel", tt.name)
+		}
+	}
+}
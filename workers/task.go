@@ -0,0 +1,68 @@
+package workers
+
+import (
+	"io"
+
+	"golang.org/x/net/context"
+)
+
+type (
+	//ContextTask is a Task whose execution stops when ctx is cancelled,
+	//Do calls ExecContext instead of Exec for tasks implementing it
+	ContextTask interface {
+		Task
+		ExecContext(context.Context, WorkerID) error
+	}
+
+	//adapter makes a ContextTask out of a Task which knows nothing about ctx
+	adapter struct {
+		Task
+	}
+
+	//wrapper is implemented by tasks executing a task on its behalf, such as a Remote task
+	//through an agent; optional interfaces of the task wrapped apply to the wrapper
+	wrapper interface {
+		wrapped() Task
+	}
+
+	//ctxReader is an io.Reader which fails once ctx is done
+	ctxReader struct {
+		ctx context.Context
+		r   io.Reader
+	}
+)
+
+//Adapt returns t as a ContextTask, tasks not implementing ContextTask ignore ctx
+func Adapt(t Task) ContextTask {
+	if ct, ok := t.(ContextTask); ok {
+		return ct
+	}
+	return adapter{t}
+}
+
+//unwrap returns the task t executes on behalf of, t itself if t is not a wrapper.
+//Optional interfaces such as Keyer or Coster are looked up on the task unwrapped.
+func unwrap(t Task) Task {
+	if w, ok := t.(wrapper); ok {
+		return w.wrapped()
+	}
+	return t
+}
+
+//ExecContext runs Exec regardless of ctx
+func (a adapter) ExecContext(ctx context.Context, w WorkerID) error {
+	return a.Exec(w)
+}
+
+//NewReader returns an io.Reader reading from r until ctx is done,
+//it lets copy and scan loops inside ExecContext honor cancellation
+func NewReader(ctx context.Context, r io.Reader) io.Reader {
+	return &ctxReader{ctx: ctx, r: r}
+}
+
+func (cr *ctxReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cr.r.Read(p)
+}
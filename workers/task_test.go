@@ -0,0 +1,86 @@
+package workers
+
+import (
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+//blocker blocks until its context is cancelled
+type blocker struct {
+	cancelled bool
+	started   chan struct{} //closed once started, nil means not told
+}
+
+//blocker implements ContextTask
+func (b *blocker) Exec(id WorkerID) error {
+	return b.ExecContext(context.Background(), id)
+}
+
+func (b *blocker) ExecContext(ctx context.Context, id WorkerID) error {
+	if b.started != nil {
+		close(b.started)
+	}
+	select {
+	case <-ctx.Done():
+		b.cancelled = true
+		return ctx.Err()
+	case <-time.After(time.Minute):
+		return nil
+	}
+}
+
+//TestContextTask test a failing task cancels running ContextTasks
+func TestContextTask(t *testing.T) {
+	errBad := errors.New("bad square")
+	b := &blocker{started: make(chan struct{})}
+	next := FromSlice([]Task{b, &square{err: errBad}})
+	c := Context{
+		DOP: 2,
+		FactoryFunc: func() (Task, error) {
+			t, err := next()
+			if t != Task(b) {
+				<-b.started //fail once the blocker runs, not while it is handed over
+			}
+			return t, err
+		},
+	}
+	start := time.Now()
+	err := Do(&c)
+	if err != errBad {
+		t.Errorf("expected err %v, actual err %v", errBad, err)
+	}
+	if !b.cancelled || time.Since(start) > 10*time.Second {
+		t.Errorf("expected blocker to be cancelled, actual cancelled %v after %v", b.cancelled, time.Since(start))
+	}
+}
+
+//TestAdapt test Adapt keeps ContextTasks and wraps plain Tasks
+func TestAdapt(t *testing.T) {
+	b := &blocker{}
+	if Adapt(b) != ContextTask(b) {
+		t.Errorf("Adapt(%v): expected the ContextTask itself", b)
+	}
+	s := &square{n: 3}
+	if err := Adapt(s).ExecContext(context.Background(), 0); err != nil || s.sq != 9 {
+		t.Errorf("Adapt(%v): expected square 9, actual %d err %v", s, s.sq, err)
+	}
+}
+
+//TestNewReader test reads fail after ctx is cancelled
+func TestNewReader(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	r := NewReader(ctx, strings.NewReader("hello"))
+	if b, err := ioutil.ReadAll(r); err != nil || string(b) != "hello" {
+		t.Errorf("expected hello, actual %q err %v", b, err)
+	}
+	cancel()
+	r = NewReader(ctx, strings.NewReader("hello"))
+	if _, err := ioutil.ReadAll(r); err != context.Canceled {
+		t.Errorf("expected err %v, actual err %v", context.Canceled, err)
+	}
+}
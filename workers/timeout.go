@@ -0,0 +1,84 @@
+package workers
+
+import (
+	"fmt"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+type (
+	//Timeouter is implemented by tasks which need a timeout other than Context.Timeout
+	Timeouter interface {
+		Timeout() time.Duration
+	}
+
+	//TimeoutError is the error of a task which did not finish within its timeout
+	TimeoutError struct {
+		Timeout   time.Duration
+		Abandoned bool //the task is not a ContextTask and its Exec may still be running
+	}
+)
+
+func (e *TimeoutError) Error() string {
+	if e.Abandoned {
+		return fmt.Sprintf("task timed out after %v, abandoned", e.Timeout)
+	}
+	return fmt.Sprintf("task timed out after %v", e.Timeout)
+}
+
+//timeout returns how long a single execution of t may take, 0 means no limit
+func (c *Context) timeout(t Task) time.Duration {
+	if tt, ok := unwrap(t).(Timeouter); ok && tt.Timeout() > 0 {
+		return tt.Timeout()
+	}
+	return c.Timeout
+}
+
+//execTimeout executes t once within d. A ContextTask sees its ctx cancelled when d expires
+//and execTimeout waits for it to return; a Task which does not return by then is abandoned,
+//its worker is freed while Exec runs on in a goroutine which may outlive the run.
+func execTimeout(ctx context.Context, clock Clock, t Task, w WorkerID, d time.Duration) error {
+	if d <= 0 {
+		return safeExec(ctx, t, w)
+	}
+	tctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	expired := make(chan struct{})
+	timer := clock.AfterFunc(d, func() {
+		close(expired)
+		cancel()
+	})
+	defer timer.Stop()
+
+	errc := make(chan error, 1) //buffered so an abandoned task can finish
+	finished := make(chan struct{})
+	tctx, exit := enter(tctx, clock)
+	go func() {
+		defer exit()
+		errc <- safeExec(tctx, t, w)
+		close(finished)
+	}()
+	awake := park(ctx, func() bool { return closed(finished) || closed(expired) })
+	select {
+	case err := <-errc:
+		awake()
+		select {
+		case <-expired: //the task gave up as its ctx was cancelled
+			if err != nil {
+				return &TimeoutError{Timeout: d}
+			}
+		default:
+		}
+		return err
+	case <-expired:
+		awake()
+		if _, ok := t.(ContextTask); ok {
+			defer park(ctx, func() bool { return closed(finished) })()
+			<-errc //its ctx is cancelled, it stops before being executed again
+			return &TimeoutError{Timeout: d}
+		}
+		exit() //a FakeClock does not wait for a task abandoned
+		return &TimeoutError{Timeout: d, Abandoned: true}
+	}
+}
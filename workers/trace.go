@@ -0,0 +1,166 @@
+package workers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+type (
+	//Tracer starts a span for each task, it must be safe for concurrent use.
+	//Tracer and Span mirror the OpenTelemetry trace API with explicit timestamps,
+	//so an OpenTelemetry tracer fits them through a thin adapter.
+	Tracer interface {
+		//Start begins a span at the given time, the returned ctx is handed to a ContextTask
+		Start(ctx context.Context, name string, at time.Time) (context.Context, Span)
+	}
+
+	//Span records a task from being queued to being finished
+	Span interface {
+		SetAttributes(attrs ...Attribute)
+		AddEvent(name string, at time.Time)
+		RecordError(err error)
+		End(at time.Time)
+	}
+
+	//Attribute is a key-value pair describing a span
+	Attribute struct {
+		Key   string
+		Value interface{}
+	}
+
+	//ChromeTracer collects spans as Chrome trace events, see WriteTo
+	ChromeTracer struct {
+		mu     sync.Mutex
+		events []chromeEvent
+		nextID int
+	}
+
+	//chromeSpan is a span of a ChromeTracer
+	chromeSpan struct {
+		t       *ChromeTracer
+		id      int
+		name    string
+		queued  time.Time
+		started time.Time
+		args    map[string]interface{}
+	}
+
+	//chromeEvent is an event of the Chrome trace-event format
+	chromeEvent struct {
+		Name  string                 `json:"name"`
+		Cat   string                 `json:"cat,omitempty"`
+		Phase string                 `json:"ph"`
+		TS    int64                  `json:"ts"` //microseconds
+		Dur   int64                  `json:"dur,omitempty"`
+		PID   int                    `json:"pid"`
+		TID   int                    `json:"tid"`
+		ID    int                    `json:"id,omitempty"`
+		Args  map[string]interface{} `json:"args,omitempty"`
+	}
+)
+
+//Attribute keys set on spans of tasks
+const (
+	AttrSeq      = "seq"
+	AttrWorkerID = "worker_id"
+	AttrAttempts = "attempts"
+	AttrStatus   = "status"
+)
+
+//startSpan starts the span of j, which has been queued since j.made, nil if c has no Tracer
+func (c *Context) startSpan(ctx context.Context, j job, w WorkerID, start time.Time) (context.Context, Span) {
+	if c.Tracer == nil {
+		return ctx, nil
+	}
+	name := "task"
+	if s, ok := j.Task.(fmt.Stringer); ok {
+		name = s.String()
+	}
+	ctx, span := c.Tracer.Start(ctx, name, j.made)
+	span.SetAttributes(Attribute{AttrSeq, j.seq}, Attribute{AttrWorkerID, int(w)})
+	span.AddEvent("started", start)
+	return ctx, span
+}
+
+//endSpan ends span with the result of its task
+func endSpan(span Span, res TaskResult) {
+	if span == nil {
+		return
+	}
+	span.SetAttributes(Attribute{AttrAttempts, res.Attempts}, Attribute{AttrStatus, res.Status.String()})
+	if res.Err != nil {
+		span.RecordError(res.Err)
+	}
+	span.End(res.Start.Add(res.Duration))
+}
+
+//NewChromeTracer returns a Tracer writing runs in the Chrome trace-event format,
+//which chrome://tracing and Perfetto display
+func NewChromeTracer() *ChromeTracer {
+	return &ChromeTracer{}
+}
+
+//Start implements Tracer
+func (t *ChromeTracer) Start(ctx context.Context, name string, at time.Time) (context.Context, Span) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.nextID++
+	return ctx, &chromeSpan{t: t, id: t.nextID, name: name, queued: at, started: at, args: map[string]interface{}{}}
+}
+
+//WriteTo writes spans ended so far as a JSON object of trace events. Execution is a complete event
+//on the thread of its worker; the time from queued to finished is an async event of category "queue".
+func (t *ChromeTracer) WriteTo(w io.Writer) (int64, error) {
+	t.mu.Lock()
+	events := t.events
+	if events == nil { //a run which failed before any task started still writes a trace viewers load
+		events = []chromeEvent{}
+	}
+	b, err := json.Marshal(struct {
+		TraceEvents     []chromeEvent `json:"traceEvents"`
+		DisplayTimeUnit string        `json:"displayTimeUnit"`
+	}{events, "ms"})
+	t.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(b)
+	return int64(n), err
+}
+
+func (s *chromeSpan) SetAttributes(attrs ...Attribute) {
+	for _, a := range attrs {
+		s.args[a.Key] = a.Value
+	}
+}
+
+func (s *chromeSpan) AddEvent(name string, at time.Time) {
+	if name == "started" {
+		s.started = at
+	}
+}
+
+func (s *chromeSpan) RecordError(err error) {
+	s.args["error"] = err.Error()
+}
+
+func (s *chromeSpan) End(at time.Time) {
+	tid, _ := s.args[AttrWorkerID].(int)
+	s.t.mu.Lock()
+	defer s.t.mu.Unlock()
+	s.t.events = append(s.t.events,
+		chromeEvent{Name: s.name, Cat: "queue", Phase: "b", TS: micros(s.queued), PID: 1, TID: tid, ID: s.id},
+		chromeEvent{Name: s.name, Cat: "queue", Phase: "e", TS: micros(at), PID: 1, TID: tid, ID: s.id},
+		chromeEvent{Name: s.name, Cat: "task", Phase: "X", TS: micros(s.started), Dur: at.Sub(s.started).Nanoseconds() / 1e3,
+			PID: 1, TID: tid, Args: s.args},
+	)
+}
+
+func micros(t time.Time) int64 {
+	return t.UnixNano() / 1e3
+}
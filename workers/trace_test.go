@@ -0,0 +1,144 @@
+package workers
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+type (
+	//recorder is a Tracer keeping spans in memory
+	recorder struct {
+		mu    sync.Mutex
+		spans []*recordedSpan
+	}
+
+	recordedSpan struct {
+		name          string
+		queued, ended time.Time
+		events        map[string]time.Time
+		attrs         map[string]interface{}
+		err           error
+	}
+
+	spanKey struct{}
+
+	//spanReader is a task reading the span found in its ctx
+	spanReader struct {
+		span interface{}
+	}
+)
+
+func (r *recorder) Start(ctx context.Context, name string, at time.Time) (context.Context, Span) {
+	s := &recordedSpan{name: name, queued: at, events: map[string]time.Time{}, attrs: map[string]interface{}{}}
+	r.mu.Lock()
+	r.spans = append(r.spans, s)
+	r.mu.Unlock()
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+func (s *recordedSpan) SetAttributes(attrs ...Attribute) {
+	for _, a := range attrs {
+		s.attrs[a.Key] = a.Value
+	}
+}
+
+func (s *recordedSpan) AddEvent(name string, at time.Time) { s.events[name] = at }
+func (s *recordedSpan) RecordError(err error)              { s.err = err }
+func (s *recordedSpan) End(at time.Time)                   { s.ended = at }
+
+func (t *spanReader) Exec(id WorkerID) error { return nil }
+
+func (t *spanReader) ExecContext(ctx context.Context, id WorkerID) error {
+	t.span = ctx.Value(spanKey{})
+	return nil
+}
+
+func TestTracer(t *testing.T) {
+	r := &recorder{}
+	err := Do(&Context{DOP: 4, FactoryFunc: factoryFuncSquares(20, 5, errTest), ErrorPolicy: ContinueOnError, Tracer: r})
+	if err == nil {
+		t.Fatal("expected task 5 to fail")
+	}
+	if len(r.spans) != 20 {
+		t.Fatalf("expected 20 spans, actual %d", len(r.spans))
+	}
+	for _, s := range r.spans {
+		started, ok := s.events["started"]
+		if !ok || started.Before(s.queued) || s.ended.Before(started) {
+			t.Errorf("expected span queued %v <= started %v <= ended %v", s.queued, started, s.ended)
+		}
+		if _, ok := s.attrs[AttrWorkerID].(int); !ok {
+			t.Errorf("expected attribute %s, actual %v", AttrWorkerID, s.attrs)
+		}
+		if s.attrs[AttrSeq] == 5 {
+			if s.err != errTest || s.attrs[AttrStatus] != Failed.String() {
+				t.Errorf("expected span of task 5 to record %v, actual %v %v", errTest, s.err, s.attrs[AttrStatus])
+			}
+		} else if s.err != nil || s.attrs[AttrStatus] != Succeeded.String() {
+			t.Errorf("expected span of task %v to succeed, actual %v %v", s.attrs[AttrSeq], s.err, s.attrs[AttrStatus])
+		}
+	}
+
+	//a ContextTask executes in the ctx of its span
+	task := &spanReader{}
+	r = &recorder{}
+	if err := Do(&Context{FactoryFunc: factoryFuncOf(task), Tracer: r}); err != nil {
+		t.Fatal(err)
+	}
+	if task.span != r.spans[0] {
+		t.Errorf("expected ctx to carry span %v, actual %v", r.spans[0], task.span)
+	}
+}
+
+func TestChromeTracer(t *testing.T) {
+	tracer := NewChromeTracer()
+	var empty bytes.Buffer
+	if _, err := tracer.WriteTo(&empty); err != nil || !bytes.Contains(empty.Bytes(), []byte(`"traceEvents":[]`)) {
+		t.Errorf("expected an empty list of events before any task, actual %s err %v", empty.String(), err)
+	}
+	err := Do(&Context{DOP: 2, FactoryFunc: factoryFuncSquares(10, 3, errTest), ErrorPolicy: ContinueOnError, Tracer: tracer})
+	if err == nil {
+		t.Fatal("expected task 3 to fail")
+	}
+
+	var b bytes.Buffer
+	if _, err := tracer.WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	var trace struct {
+		TraceEvents []struct {
+			Name  string                 `json:"name"`
+			Phase string                 `json:"ph"`
+			TS    int64                  `json:"ts"`
+			Dur   int64                  `json:"dur"`
+			TID   int                    `json:"tid"`
+			Args  map[string]interface{} `json:"args"`
+		} `json:"traceEvents"`
+	}
+	if err := json.Unmarshal(b.Bytes(), &trace); err != nil {
+		t.Fatalf("expected JSON, actual %v\n%s", err, b.String())
+	}
+	phases := map[string]int{}
+	workers := map[int]bool{}
+	for _, e := range trace.TraceEvents {
+		phases[e.Phase]++
+		if e.Phase != "X" {
+			continue
+		}
+		workers[e.TID] = true
+		if e.Args["error"] != nil && e.Args[AttrSeq] != float64(3) {
+			t.Errorf("expected only task 3 to fail, actual %v", e.Args)
+		}
+	}
+	if phases["X"] != 10 || phases["b"] != 10 || phases["e"] != 10 {
+		t.Errorf("expected 10 complete and 10 async events of each phase, actual %v", phases)
+	}
+	if len(workers) > 2 {
+		t.Errorf("expected events on at most 2 worker threads, actual %v", workers)
+	}
+}
@@ -0,0 +1,159 @@
+package workers
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type (
+	//Stats are counters of tasks executed by a Handle
+	Stats struct {
+		Done   int64         //number of tasks executed
+		Failed int64         //number of tasks failed
+		Busy   time.Duration //sum of execution time of tasks executed
+	}
+
+	//Tuner adjusts DOP of a run between Min and Max to maximize throughput.
+	//Like AIMD in TCP congestion control, it adds a worker while throughput keeps rising
+	//and cuts workers multiplicatively once throughput falls.
+	Tuner struct {
+		Min, Max int
+		//Interval is how often throughput and latency are sampled, defaults to 1s
+		Interval time.Duration
+		//Decrease is the factor DOP is multiplied by when throughput falls, defaults to 0.5
+		Decrease float64
+		//Tolerance is the relative change of throughput or latency regarded as noise, defaults to 0.05
+		Tolerance float64
+		//OnAdjust is called with every DOP change, nil means log the change
+		OnAdjust func(Decision)
+
+		mu        sync.Mutex
+		decisions []Decision
+	}
+
+	//Decision records a DOP change made by a Tuner
+	Decision struct {
+		Time       time.Time
+		From, To   int
+		Throughput float64       //tasks executed per second during the last interval
+		Latency    time.Duration //mean execution time of tasks during the last interval
+		Reason     string
+	}
+
+	//sample is throughput and latency measured over an interval
+	sample struct {
+		throughput float64
+		latency    time.Duration
+	}
+)
+
+//Stats returns counters of tasks executed so far
+func (h *Handle) Stats() Stats {
+	return Stats{
+		Done:   atomic.LoadInt64(&h.numDone),
+		Failed: atomic.LoadInt64(&h.numFailed),
+		Busy:   time.Duration(atomic.LoadInt64(&h.busy)),
+	}
+}
+
+//Decisions returns DOP changes made so far
+func (t *Tuner) Decisions() []Decision {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]Decision{}, t.decisions...)
+}
+
+//run samples h every Interval and resizes it until all workers exited
+func (t *Tuner) run(h *Handle) {
+	interval := t.Interval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	h.Resize(t.clamp(h.DOP()))
+
+	clock := h.c.clock()
+	ticker := clock.NewTicker(interval)
+	defer ticker.Stop()
+	var prev *sample
+	last, lastTime := h.Stats(), clock.Now()
+	for {
+		select {
+		case <-h.stopped:
+			return
+		case now := <-ticker.C():
+			stats := h.Stats()
+			done := stats.Done - last.Done
+			if done == 0 { //tasks run longer than interval, nothing to learn yet
+				continue
+			}
+			cur := &sample{
+				throughput: float64(done) / now.Sub(lastTime).Seconds(),
+				latency:    (stats.Busy - last.Busy) / time.Duration(done),
+			}
+			last, lastTime = stats, now
+
+			from := h.DOP()
+			to, reason := t.decide(from, prev, cur)
+			prev = cur
+			if to == from {
+				continue
+			}
+			h.Resize(to)
+			t.record(Decision{Time: now, From: from, To: to, Throughput: cur.throughput, Latency: cur.latency, Reason: reason})
+		}
+	}
+}
+
+//decide returns the DOP to use after sampling cur, prev is nil on the first sample
+func (t *Tuner) decide(dop int, prev, cur *sample) (int, string) {
+	tolerance := t.Tolerance
+	if tolerance <= 0 {
+		tolerance = 0.05
+	}
+	decrease := t.Decrease
+	if decrease <= 0 || decrease >= 1 {
+		decrease = 0.5
+	}
+
+	if prev == nil {
+		return t.clamp(dop + 1), "probing"
+	}
+	gain := cur.throughput/prev.throughput - 1
+	switch {
+	case gain > tolerance:
+		return t.clamp(dop + 1), "throughput rose"
+	case gain < -tolerance:
+		return t.clamp(int(float64(dop) * decrease)), "throughput fell"
+	case float64(cur.latency) > float64(prev.latency)*(1+tolerance):
+		return t.clamp(dop - 1), "latency rose with flat throughput"
+	}
+	return t.clamp(dop + 1), "probing"
+}
+
+//clamp keeps n within [Min, Max]
+func (t *Tuner) clamp(n int) int {
+	if t.Max > 0 && n > t.Max {
+		n = t.Max
+	}
+	if n < t.Min {
+		n = t.Min
+	}
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+func (t *Tuner) record(d Decision) {
+	t.mu.Lock()
+	t.decisions = append(t.decisions, d)
+	t.mu.Unlock()
+
+	if t.OnAdjust != nil {
+		t.OnAdjust(d)
+		return
+	}
+	log.Printf("DOP %d -> %d: %s, %.1f tasks/s, latency %v", d.From, d.To, d.Reason, d.Throughput, d.Latency)
+}
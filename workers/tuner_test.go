@@ -0,0 +1,90 @@
+package workers
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+//TestDecide test DOP changes for throughput and latency samples
+func TestDecide(t *testing.T) {
+	tuner := &Tuner{Min: 2, Max: 8}
+	tests := []struct {
+		dop      int
+		prev     *sample
+		cur      sample
+		expected int
+	}{
+		{4, nil, sample{10, time.Second}, 5},
+		{4, &sample{10, time.Second}, sample{20, time.Second}, 5},
+		{8, &sample{10, time.Second}, sample{20, time.Second}, 8},
+		{6, &sample{20, time.Second}, sample{10, time.Second}, 3},
+		{3, &sample{20, time.Second}, sample{10, time.Second}, 2},
+		{4, &sample{10, time.Second}, sample{10, 2 * time.Second}, 3},
+		{4, &sample{10, time.Second}, sample{10, time.Second}, 5},
+	}
+	for _, tt := range tests {
+		cur := tt.cur
+		if actual, reason := tuner.decide(tt.dop, tt.prev, &cur); actual != tt.expected {
+			t.Errorf("decide(%d, %v, %v): expected %d, actual %d (%s)", tt.dop, tt.prev, tt.cur, tt.expected, actual, reason)
+		}
+	}
+}
+
+//contended is a Task which slows down when more than capacity of them run at the same time
+type contended struct {
+	mu       *sync.Mutex
+	active   *int
+	capacity int
+}
+
+//contended implements Task
+func (c contended) Exec(id WorkerID) error {
+	c.mu.Lock()
+	*c.active++
+	d := 2 * time.Millisecond
+	if *c.active > c.capacity {
+		d = d * time.Duration(*c.active) / time.Duration(c.capacity)
+	}
+	c.mu.Unlock()
+
+	time.Sleep(d)
+
+	c.mu.Lock()
+	*c.active--
+	c.mu.Unlock()
+	return nil
+}
+
+//TestTuner test a Tuner keeps DOP within bounds while adjusting it
+func TestTuner(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		active int
+		tasks  []Task
+	)
+	for i := 0; i < 2000; i++ {
+		tasks = append(tasks, contended{mu: &mu, active: &active, capacity: 4})
+	}
+	tuner := &Tuner{Min: 1, Max: 12, Interval: 20 * time.Millisecond, OnAdjust: func(Decision) {}}
+	c := Context{DOP: 1, FactoryFunc: factoryFuncOf(tasks...), Tuner: tuner}
+	report, err := DoReport(&c)
+	if err != nil || len(report.Results) != len(tasks) {
+		t.Fatalf("expected %d tasks executed, actual %d err %v", len(tasks), len(report.Results), err)
+	}
+
+	decisions := tuner.Decisions()
+	if len(decisions) == 0 {
+		t.Fatal("expected DOP to be adjusted")
+	}
+	grew := false
+	for _, d := range decisions {
+		if d.To < tuner.Min || d.To > tuner.Max {
+			t.Errorf("expected DOP within [%d, %d], actual %+v", tuner.Min, tuner.Max, d)
+		}
+		grew = grew || d.To > 1
+	}
+	if !grew {
+		t.Errorf("expected DOP to grow beyond 1, actual %+v", decisions)
+	}
+}
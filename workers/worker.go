@@ -1,6 +1,10 @@
 package workers
 
 import (
+	"sync"
+	"sync/atomic"
+	"time"
+
 	"golang.org/x/net/context"
 	"golang.org/x/sync/errgroup"
 )
@@ -14,61 +18,413 @@ type (
 		Exec(WorkerID) error
 	}
 
-	//FactoryFunc is the function to be invoked to make instances of Task
-	FactoryFunc func() Task
+	//FactoryFunc is the function to be invoked to make instances of Task.
+	//It returns a nil Task and a nil error when there are no more tasks;
+	//a non-nil error stops the run and is returned by Do.
+	//A call still blocked once the run is cancelled, e.g. on an idle channel, is abandoned:
+	//Do returns without waiting for it, a Task it makes then is cleaned up and not executed.
+	FactoryFunc func() (Task, error)
 
 	// Context specifies controls of concurrent task executions
 	Context struct {
 		context.Context
 		DOP int
 		FactoryFunc
+
+		//ErrorPolicy decides whether a failed task stops the run
+		ErrorPolicy
+		//MaxFailures is for StopAfterFailures: the run stops once this many tasks failed, <= 0 means at the first failure
+		MaxFailures int
+		//Retry executes failed tasks again, nil means no retry
+		Retry *RetryPolicy
+		//Tuner adjusts DOP while tasks are executed, nil means DOP is fixed
+		Tuner *Tuner
+
+		//OnTaskStart is called by a worker before executing a task, it must be safe for concurrent use
+		OnTaskStart func(TaskResult)
+		//OnTaskDone is called by a worker with the result of each task, it must be safe for concurrent use
+		OnTaskDone func(TaskResult)
+		//Progress reports tasks done and ETA while tasks are executed
+		Progress *Progress
+		//Scheduler orders tasks by priority, nil means tasks are executed as FactoryFunc makes them
+		Scheduler *Scheduler
+		//Timeout limits each execution of a task unless the task is a Timeouter, 0 means no limit.
+		//A Task which is not a ContextTask cannot be stopped: once timed out it is abandoned and not retried,
+		//the goroutine executing it leaks until Exec returns, possibly after Do returned.
+		Timeout time.Duration
+		//RateLimit caps how fast tasks start, nil means tasks start as soon as a worker is free
+		RateLimit *RateLimit
+		//AbortOnPanic stops the run once a task panics, otherwise a panic fails the task like an error
+		AbortOnPanic bool
+		//Metrics counts tasks and their durations, it may be shared by several runs
+		Metrics *Metrics
+		//Tracer records a span per task from being queued to being finished, nil means no tracing
+		Tracer Tracer
+		//Journal skips Keyer tasks completed by earlier runs and records those succeeded, nil means no journal
+		Journal *Journal
+		//Drain stops handing out tasks once done, tasks already handed out finish unless Context is done.
+		//The run then returns ErrDrained, see NotifyShutdown.
+		Drain context.Context
+		//Clock tells the time to the run and makes its timers, nil means the system clock
+		Clock Clock
+		//Budget caps resources taken by Coster tasks executed at once, a zero field means no limit.
+		//DOP still caps the number of tasks, set it high enough for Budget to be the limit.
+		Budget Resources
+
+		waitFactory bool //FactoryFunc returns once the run is cancelled, the generator waits for it rather than abandon it
+	}
+
+	//job is a task made by FactoryFunc along with its sequence number
+	job struct {
+		seq  int
+		made time.Time //when FactoryFunc made the task, the task is queued since then
+		Task
+	}
+
+	//Handle controls tasks being executed by Start
+	Handle struct {
+		numDone   int64 //64-bit atomics first to be aligned on 32-bit platforms
+		numFailed int64
+		busy      int64 //nanoseconds spent executing tasks
+
+		c      *Context
+		ctx    context.Context
+		cancel context.CancelFunc
+		g      *errgroup.Group
+		tasks  chan job
+		slots  *slots //DOP slots and resources taken by tasks being executed
+		report *Report
+
+		mu      sync.Mutex
+		quits   []chan struct{} //one per worker counted by DOP
+		live    int             //running worker goroutines, including those told to quit
+		nextID  WorkerID
+		drained bool //FactoryFunc made the last task
+
+		stopped    chan struct{}  //closed once all workers exited
+		bg         sync.WaitGroup //helpers such as Tuner and Progress running alongside workers
+		done       chan struct{}
+		factoryErr error
+		drainErr   error //set once Context.Drain stopped the generator before the last task
+		err        error
 	}
 )
 
 //Do execute tasks in parallel
 func Do(c *Context) error {
-	numWorkers := c.DOP
+	_, err := DoReport(c)
+	return err
+}
+
+//DoReport execute tasks in parallel and returns a Report of tasks executed
+func DoReport(c *Context) (*Report, error) {
+	return Start(c).Wait()
+}
+
+//Start launches c.DOP workers executing tasks in parallel and returns without waiting for them
+func Start(c *Context) *Handle {
+	return start(c, &Report{})
+}
+
+//start launches workers recording results in report, nil means results are not kept
+func start(c *Context, report *Report) *Handle {
+	if c.Context == nil {
+		c.Context = context.Background()
+	}
+	h := &Handle{c: c, report: report, tasks: make(chan job), stopped: make(chan struct{}), done: make(chan struct{})}
+	h.slots = &slots{size: c.DOP, budget: c.Budget}
+	if c.DOP < 1 {
+		h.slots.size = 1
+	}
+	ctx, cancel := context.WithCancel(c.Context)
+	h.g, h.ctx = errgroup.WithContext(ctx)
+	h.cancel = cancel
+
+	//generate tasks, the generator is part of the errgroup so Wait never returns before it exits
+	if c.Scheduler != nil {
+		h.g.Go(func() error { return c.Scheduler.generate(h) })
+	} else {
+		h.g.Go(h.generate)
+	}
+
+	//launch workers, at least one
+	h.mu.Lock()
+	for i := 0; i < c.DOP || i == 0; i++ {
+		h.spawn()
+	}
+	h.mu.Unlock()
+
+	if c.Tuner != nil {
+		h.background(c.Tuner.run)
+	}
+	if c.Progress != nil {
+		h.background(c.Progress.run)
+	}
 
-	tasks := make(chan Task)
-	//generate tasks
 	go func() {
-		for {
-			task := c.FactoryFunc()
-			if task == nil { //no more tasks
-				close(tasks)
-				return
+		//wait for all workers done or a worker returns an error
+		err := h.g.Wait()
+		close(h.stopped)
+		h.bg.Wait()
+		h.report.sort()
+		if _, aborted := err.(*PanicError); !aborted && c.ErrorPolicy != FailFast {
+			if m := multiError(h.report); m != nil {
+				err = m
 			}
-			tasks <- task
 		}
+		if err == nil {
+			err = h.drainErr
+		}
+		if h.factoryErr != nil {
+			err = h.factoryErr
+		}
+		h.err = err
+		h.cancel()
+		close(h.done)
 	}()
+	return h
+}
 
-	if c.Context == nil {
-		c.Context = context.Background()
+//generate sends tasks made by FactoryFunc to workers until there are no more tasks, the run is drained or cancelled
+func (h *Handle) generate() error {
+	defer close(h.tasks)
+	for seq := 0; ; seq++ {
+		task, err := h.make()
+		if err != nil {
+			h.factoryErr = err //read after g.Wait returns
+			return err
+		}
+		if task == nil {
+			return nil
+		}
+		if h.c.draining() {
+			h.drainErr = ErrDrained
+			return nil
+		}
+		select {
+		case h.tasks <- job{seq: seq, made: h.c.clock().Now(), Task: task}:
+		case <-h.c.drain():
+			h.drainErr = ErrDrained
+			return nil
+		case <-h.ctx.Done():
+			return nil
+		}
+	}
+}
+
+//make calls FactoryFunc for the generator, it returns no task once the run is cancelled
+//rather than wait for a call blocked meanwhile
+func (h *Handle) make() (Task, error) {
+	if h.c.waitFactory {
+		return h.c.FactoryFunc()
+	}
+	type made struct {
+		task Task
+		err  error
+	}
+	c := make(chan made)
+	go func() {
+		task, err := h.c.FactoryFunc()
+		select {
+		case c <- made{task, err}:
+		case <-h.ctx.Done(): //abandoned
+			if task != nil {
+				cleanup(task, h.ctx.Err())
+			}
+		}
+	}()
+	select {
+	case m := <-c:
+		return m.task, m.err
+	case <-h.ctx.Done():
+		return nil, nil
+	}
+}
+
+//background runs f alongside workers, f must return once h.stopped is closed
+func (h *Handle) background(f func(*Handle)) {
+	h.bg.Add(1)
+	go func() {
+		defer h.bg.Done()
+		f(h)
+	}()
+}
+
+//Wait blocks until all tasks are executed or the run is stopped by an error
+func (h *Handle) Wait() (*Report, error) {
+	<-h.done
+	return h.report, h.err
+}
+
+//DOP returns the number of workers the run is sized to
+func (h *Handle) DOP() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.quits)
+}
+
+//Resize changes the number of workers to n, which must be >= 1.
+//Extra workers quit after finishing their current task; new workers are started
+//as long as there are tasks left to execute.
+func (h *Handle) Resize(n int) {
+	if n < 1 {
+		n = 1
 	}
-	g, ctx := errgroup.WithContext(c.Context)
-
-	//launch workers
-	for i := 0; i < numWorkers; i++ {
-		w := WorkerID(i)
-
-		//stand a go rountine
-		g.Go(func() error {
-			for {
-				select {
-				case tsk := <-tasks:
-					if tsk == nil {
-						return nil
-					}
-					err := tsk.Exec(w)
-					if err != nil {
-						return err
-					}
-				case <-ctx.Done():
-					return ctx.Err()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for len(h.quits) > n {
+		last := len(h.quits) - 1
+		close(h.quits[last])
+		h.quits = h.quits[:last]
+	}
+	//once all workers exited the errgroup can take no more goroutines
+	for len(h.quits) < n && h.live > 0 && !h.drained && h.ctx.Err() == nil {
+		h.spawn()
+	}
+	h.slots.resize(len(h.quits))
+}
+
+//spawn starts a worker, h.mu must be held
+func (h *Handle) spawn() {
+	w, quit := h.nextID, make(chan struct{})
+	h.nextID++
+	h.live++
+	h.quits = append(h.quits, quit)
+
+	//stand a go rountine, a FakeClock waits for it from now on
+	ctx, exit := enter(h.ctx, h.c.clock())
+	h.g.Go(func() error {
+		defer func() {
+			h.mu.Lock()
+			h.live--
+			h.mu.Unlock()
+			exit()
+		}()
+		return h.work(ctx, w, quit)
+	})
+}
+
+//work executes tasks until there are no more tasks, quit is closed or ctx, that of the run, is cancelled
+func (h *Handle) work(ctx context.Context, w WorkerID, quit chan struct{}) error {
+	for {
+		select {
+		case <-quit:
+			return nil
+		default:
+		}
+
+		select {
+		case j, ok := <-h.tasks:
+			if !ok { //no more tasks, or the generator stopped as the run was cancelled
+				h.mu.Lock()
+				h.drained = true
+				h.mu.Unlock()
+				return ctx.Err()
+			}
+			if err := ctx.Err(); err != nil { //cancelled while the task was handed over
+				h.report.add(h.c.cancelled(j, w, err))
+				return err
+			}
+			if res, ok := h.c.skip(j, w); ok {
+				h.report.add(res)
+				atomic.AddInt64(&h.numDone, 1)
+				continue
+			}
+			//wait for a token before taking a slot, a throttled task must not hold one others could use
+			if err := h.c.RateLimit.wait(ctx, h.c.clock(), j.Task); err != nil {
+				h.report.add(h.c.cancelled(j, w, err))
+				return err
+			}
+			n, err := h.slots.acquire(ctx, cost(j.Task))
+			if err != nil {
+				h.report.add(h.c.cancelled(j, w, err))
+				return err
+			}
+			res := h.c.exec(ctx, j, w)
+			h.slots.release(n)
+			h.report.add(res)
+			atomic.AddInt64(&h.numDone, 1)
+			atomic.AddInt64(&h.busy, int64(res.Duration))
+			if res.Err == nil {
+				continue
+			}
+			numFailed := atomic.AddInt64(&h.numFailed, 1)
+			if res.Status == Panicked && h.c.AbortOnPanic {
+				return res.Err
+			}
+			switch h.c.ErrorPolicy {
+			case FailFast:
+				return res.Err
+			case StopAfterFailures:
+				if int(numFailed) >= h.c.MaxFailures {
+					h.cancel()
 				}
 			}
-		})
+		case <-quit:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+//exec runs a task, retrying it as c.Retry allows, and records its result
+func (c *Context) exec(ctx context.Context, j job, w WorkerID) TaskResult {
+	clock := c.clock()
+	res := TaskResult{Task: j.Task, Seq: j.seq, WorkerID: w, Start: clock.Now()}
+	c.Metrics.start(res.Start.Sub(j.made))
+	ctx, span := c.startSpan(ctx, j, w, res.Start)
+	if c.OnTaskStart != nil {
+		c.OnTaskStart(res)
+	}
+	for {
+		res.Attempts++
+		res.Err = execTimeout(ctx, clock, j.Task, w, c.timeout(j.Task))
+		if _, panicked := res.Err.(*PanicError); panicked { //a panic is a bug, not worth a retry
+			break
+		}
+		if e, ok := res.Err.(*TimeoutError); ok && e.Abandoned { //another Exec would race with the one running on
+			break
+		}
+		if res.Err == nil || !c.Retry.retry(res.Attempts, res.Err) || !c.Retry.wait(ctx, clock, res.Attempts) {
+			break
+		}
+	}
+	res.Duration = clock.Now().Sub(res.Start)
+
+	switch res.Err.(type) {
+	case nil:
+		res.Status = Succeeded
+		if rt, ok := unwrap(j.Task).(ResultTask); ok {
+			res.Value = rt.Result()
+		}
+		if err := c.record(j.Task); err != nil { //the task would be executed again by the next run
+			res.Status, res.Err = Failed, err
+		}
+	case *TimeoutError:
+		res.Status = TimedOut
+	case *PanicError:
+		res.Status = Panicked
+	default:
+		res.Status = Failed
+	}
+	if res.Err != nil {
+		cleanup(j.Task, res.Err)
+	}
+	c.Metrics.done(res)
+	endSpan(span, res)
+	if c.OnTaskDone != nil {
+		c.OnTaskDone(res)
+	}
+	return res
+}
+
+//cancelled returns the result of j handed to a worker but not executed as the run was cancelled
+func (c *Context) cancelled(j job, w WorkerID, err error) TaskResult {
+	res := TaskResult{Task: j.Task, Seq: j.seq, WorkerID: w, Status: Cancelled, Start: c.clock().Now(), Err: err}
+	cleanup(j.Task, err)
+	if c.OnTaskDone != nil {
+		c.OnTaskDone(res)
 	}
-	//wait for all workers done or a worker returns an error
-	return g.Wait()
+	return res
 }
@@ -2,10 +2,16 @@ package workers
 
 import (
 	"errors"
+	"io/ioutil"
 	"log"
 	"math"
+	"runtime"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"golang.org/x/net/context"
 )
 
 //test cases
@@ -31,56 +37,79 @@ var WorkerTests = []struct {
 	{4, 5, 1, nil},
 }
 
+//timer sleeps one second of its clock
 type timer struct {
 	id    int
+	clock Clock
 	start time.Time
 	end   time.Time
 }
 
-//timer implements Task
-func (tm *timer) Exec(id WorkerID) error {
-	tm.start = time.Now()
-	time.Sleep(time.Second)
-	tm.end = time.Now()
+//timer implements ContextTask
+func (tm *timer) ExecContext(ctx context.Context, id WorkerID) error {
+	tm.start = tm.clock.Now()
+	if err := tm.clock.Sleep(ctx, time.Second); err != nil {
+		return err
+	}
+	tm.end = tm.clock.Now()
 	// log.Printf("worker ID %d timer %v %v\n", id, tm.start, tm.end)
 	return nil
 }
 
+func (tm *timer) Exec(id WorkerID) error {
+	return tm.ExecContext(context.Background(), id)
+}
+
 //factoryFuncNoErr returns a FactoryFunc which makes Task when called
 func factoryFuncNoErr(timers []*timer) FactoryFunc {
 	var index int
-	return func() Task {
+	return func() (Task, error) {
 		if index == len(timers) { //
-			return nil
+			return nil, nil
 		}
 		tm := timers[index]
 		index++
-		return tm
+		return tm, nil
 	}
 }
 
-//createTimers returns N timers
-func createTimers(N int) []*timer {
+//createTimers returns N timers sleeping on clock
+func createTimers(N int, clock Clock) []*timer {
 	timers := []*timer{}
 	for i := 0; i < N; i++ {
-		timers = append(timers, &timer{id: i})
+		timers = append(timers, &timer{id: i, clock: clock})
 	}
 	return timers
 }
 
+//simulate runs c on clock, which moves each time workers all wait on it, so that the run
+//takes as long on clock as it would on the system clock, only in no time.
+//Tasks made by c must wait on nothing but clock, with the ctx they are given.
+func simulate(t *testing.T, clock *FakeClock, c *Context) (*Report, error) {
+	c.Clock = clock
+	h := Start(c)
+	if err := clock.Run(h.done); err != nil {
+		t.Error(err)
+		h.cancel()
+	}
+	return h.Wait()
+}
+
 var errZeroTime = errors.New("start or end time not set")
 
+var errTest = errors.New("test error")
+
 //calcuateElapsedTime returns elapsed time and number of tasks not executed
 func calcuateElapsedTime(timers []*timer) (time.Duration, int) {
-	minStart := time.Now()
-	maxEnd := time.Now().Add(-time.Hour)
+	var minStart, maxEnd time.Time
 	numNotExecuted := 0
 
 	for _, tm := range timers {
 		if (tm.start == time.Time{}) || (tm.end == time.Time{}) {
 			numNotExecuted++
+			continue
 		}
-		if tm.start.Before(minStart) {
+		if minStart.IsZero() || tm.start.Before(minStart) {
 			minStart = tm.start
 		}
 		if tm.end.After(maxEnd) {
@@ -93,15 +122,15 @@ func calcuateElapsedTime(timers []*timer) (time.Duration, int) {
 //TestDo test Do func which execute timers in parallel
 func TestDo(t *testing.T) {
 	for _, tt := range WorkerTests {
-		log.Printf("test case %+v", tt)
-		timers := createTimers(tt.numTask)
+		clock := NewFakeClock(time.Unix(0, 0))
+		timers := createTimers(tt.numTask, clock)
 
 		ctx := Context{
 			DOP:         tt.DOP,
 			FactoryFunc: factoryFuncNoErr(timers),
 		}
 
-		err := Do(&ctx)
+		_, err := simulate(t, clock, &ctx)
 		if err != tt.expectedErr {
 			t.Errorf("expected err %v, actual err %v", tt.expectedErr, err)
 		}
@@ -110,9 +139,497 @@ func TestDo(t *testing.T) {
 			t.Errorf("Do(%v): expected %d tasks be executed, actual %d tasks not executed", ctx, tt.numTask, numNotExecuted)
 		}
 
-		actualSec := actualDuration.Seconds()
-		if math.Abs(actualSec-tt.expectedSec) >= 1 {
-			t.Errorf("Do(%v): expected tasks to complete in %v, actual %v", ctx, tt.expectedSec, actualSec)
+		if actualSec := actualDuration.Seconds(); actualSec != tt.expectedSec {
+			t.Errorf("Do(%+v): expected tasks to complete in %v, actual %v", tt, tt.expectedSec, actualSec)
+		}
+		for _, tm := range timers {
+			running := 0
+			for _, other := range timers {
+				if other.start.Equal(tm.start) {
+					running++
+				}
+			}
+			if running > tt.DOP {
+				t.Errorf("Do(%+v): expected at most %d timers at once, actual %d", tt, tt.DOP, running)
+			}
+		}
+	}
+}
+
+type square struct {
+	n   int
+	err error
+	sq  int
+}
+
+//square implements ResultTask
+func (s *square) Exec(id WorkerID) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.sq = s.n * s.n
+	return nil
+}
+
+func (s *square) Result() interface{} {
+	return s.sq
+}
+
+//factoryFuncSquares returns a FactoryFunc which makes squares of 0..N-1
+func factoryFuncSquares(N int, errAt int, err error) FactoryFunc {
+	var index int
+	return func() (Task, error) {
+		if index == N {
+			return nil, nil
+		}
+		s := &square{n: index}
+		if index == errAt {
+			s.err = err
+		}
+		index++
+		return s, nil
+	}
+}
+
+//TestDoReport test results collected by DoReport
+func TestDoReport(t *testing.T) {
+	c := Context{DOP: 3, FactoryFunc: factoryFuncSquares(10, -1, nil)}
+	report, err := DoReport(&c)
+	if err != nil {
+		t.Fatalf("DoReport: unexpected err %v", err)
+	}
+	if len(report.Results) != 10 {
+		t.Fatalf("DoReport: expected 10 results, actual %d", len(report.Results))
+	}
+	for i, v := range report.Values() {
+		if v.(int) != i*i {
+			t.Errorf("DoReport: expected value %d at %d, actual %v", i*i, i, v)
+		}
+		if res := report.Results[i]; res.Seq != i || res.Status != Succeeded || int(res.WorkerID) >= c.DOP {
+			t.Errorf("DoReport: unexpected result %+v", res)
+		}
+	}
+
+	errBad := errors.New("bad square")
+	c = Context{DOP: 1, FactoryFunc: factoryFuncSquares(10, 4, errBad)}
+	report, err = DoReport(&c)
+	if err != errBad {
+		t.Errorf("DoReport: expected err %v, actual err %v", errBad, err)
+	}
+	failed := report.Failed()
+	if len(failed) != 1 || failed[0].Seq != 4 || failed[0].Status != Failed || failed[0].Err != errBad {
+		t.Errorf("DoReport: expected task 4 to fail, actual %+v", failed)
+	}
+}
+
+//factoryFuncFailing returns a FactoryFunc which makes N squares, every other one fails
+func factoryFuncFailing(N int, err error) FactoryFunc {
+	var index int
+	return func() (Task, error) {
+		if index == N {
+			return nil, nil
+		}
+		s := &square{n: index}
+		if index%2 == 1 {
+			s.err = err
+		}
+		index++
+		return s, nil
+	}
+}
+
+//TestErrorPolicy test Do with each ErrorPolicy
+func TestErrorPolicy(t *testing.T) {
+	errOdd := errors.New("odd square")
+	tests := []struct {
+		policy       ErrorPolicy
+		maxFailures  int
+		expectedRun  int
+		expectedFail int
+	}{
+		{ContinueOnError, 0, 10, 5},
+		{StopAfterFailures, 0, 2, 1}, //stops at the first failure
+		{StopAfterFailures, 2, 4, 2},
+		{StopAfterFailures, 5, 10, 5},
+	}
+	for _, tt := range tests {
+		c := Context{
+			DOP:         1,
+			FactoryFunc: factoryFuncFailing(10, errOdd),
+			ErrorPolicy: tt.policy,
+			MaxFailures: tt.maxFailures,
+		}
+		report, err := DoReport(&c)
+		m, ok := err.(MultiError)
+		if !ok {
+			t.Fatalf("policy %v: expected MultiError, actual %v", tt.policy, err)
+		}
+		if len(m) != tt.expectedFail {
+			t.Errorf("policy %v: expected %d errors, actual %d", tt.policy, tt.expectedFail, len(m))
+		}
+		for _, e := range m {
+			if e.Err != errOdd || e.Seq%2 != 1 {
+				t.Errorf("policy %v: unexpected task error %v", tt.policy, e)
+			}
+		}
+		//with a single worker at most one task made after the last failure may still run
+		if run := len(report.Results); run < tt.expectedRun || run > tt.expectedRun+1 {
+			t.Errorf("policy %v: expected %d tasks executed, actual %d", tt.policy, tt.expectedRun, run)
+		}
+	}
+}
+
+//factoryFuncOf returns a FactoryFunc which makes the given tasks
+func factoryFuncOf(tasks ...Task) FactoryFunc {
+	return FromSlice(tasks)
+}
+
+//flaky fails its first numFailures executions
+type flaky struct {
+	numFailures int
+	numExec     int
+	err         error
+}
+
+//flaky implements Task
+func (f *flaky) Exec(id WorkerID) error {
+	f.numExec++
+	if f.numExec <= f.numFailures {
+		return f.err
+	}
+	return nil
+}
+
+//TestRetry test failed tasks are executed again as RetryPolicy allows
+func TestRetry(t *testing.T) {
+	errTransient := errors.New("transient")
+	errPermanent := errors.New("permanent")
+	policy := &RetryPolicy{
+		MaxAttempts: 3,
+		Backoff:     time.Millisecond,
+		Jitter:      0.5,
+		Retryable:   func(err error) bool { return err == errTransient },
+	}
+	tests := []struct {
+		task             *flaky
+		expectedAttempts int
+		expectedStatus   Status
+	}{
+		{&flaky{numFailures: 0, err: errTransient}, 1, Succeeded},
+		{&flaky{numFailures: 2, err: errTransient}, 3, Succeeded},
+		{&flaky{numFailures: 3, err: errTransient}, 3, Failed},
+		{&flaky{numFailures: 1, err: errPermanent}, 1, Failed},
+	}
+	for _, tt := range tests {
+		c := Context{
+			DOP:         1,
+			FactoryFunc: factoryFuncOf(tt.task),
+			ErrorPolicy: ContinueOnError,
+			Retry:       policy,
+		}
+		report, _ := DoReport(&c)
+		res := report.Results[0]
+		if res.Attempts != tt.expectedAttempts || res.Status != tt.expectedStatus {
+			t.Errorf("expected %d attempts and %v, actual %d attempts and %v", tt.expectedAttempts, tt.expectedStatus, res.Attempts, res.Status)
+		}
+	}
+}
+
+//TestBackoff test delays grow exponentially up to MaxBackoff
+func TestBackoff(t *testing.T) {
+	p := &RetryPolicy{Backoff: time.Second, MaxBackoff: 5 * time.Second}
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i, d := range expected {
+		if actual := p.backoff(i + 1); actual != d {
+			t.Errorf("backoff(%d): expected %v, actual %v", i+1, d, actual)
+		}
+	}
+	p.Jitter = 0.1
+	for i := 0; i < 100; i++ {
+		if d := p.backoff(1); d < 900*time.Millisecond || d > 1100*time.Millisecond {
+			t.Errorf("backoff(1) with jitter 0.1: expected within 10%% of 1s, actual %v", d)
+		}
+	}
+	//without MaxBackoff the delay grows up to the longest Duration rather than overflow
+	p = &RetryPolicy{Backoff: time.Second}
+	for _, attempt := range []int{35, 64, 2000} {
+		if d := p.backoff(attempt); d != math.MaxInt64 {
+			t.Errorf("backoff(%d) without MaxBackoff: expected %v, actual %v", attempt, time.Duration(math.MaxInt64), d)
 		}
 	}
+	p.Jitter = 0.1
+	if d := p.backoff(35); d < 0 {
+		t.Errorf("backoff(35) with jitter 0.1: expected a positive delay, actual %v", d)
+	}
+}
+
+//gauge tracks how many probes run at the same time
+type gauge struct {
+	mu     sync.Mutex
+	active int
+	max    int
+	clock  *FakeClock //probes sleep on, nil means they sleep for real whatever their ctx
+}
+
+//probe is a Task recording the concurrency it started with
+type probe struct {
+	g      *gauge
+	d      time.Duration
+	start  time.Time
+	active int
+}
+
+//probe implements ContextTask
+func (p *probe) ExecContext(ctx context.Context, id WorkerID) error {
+	p.g.mu.Lock()
+	p.g.active++
+	p.active, p.start = p.g.active, p.g.now()
+	if p.g.active > p.g.max {
+		p.g.max = p.g.active
+	}
+	p.g.mu.Unlock()
+
+	var err error
+	if p.g.clock != nil {
+		err = p.g.clock.Sleep(ctx, p.d)
+	} else {
+		time.Sleep(p.d)
+	}
+
+	p.g.mu.Lock()
+	p.g.active--
+	p.g.mu.Unlock()
+	return err
+}
+
+func (p *probe) Exec(id WorkerID) error {
+	return p.ExecContext(context.Background(), id)
+}
+
+func (g *gauge) now() time.Time {
+	if g.clock != nil {
+		return g.clock.Now()
+	}
+	return time.Now()
+}
+
+//createProbes returns N probes sharing a gauge
+func createProbes(N int, d time.Duration) (*gauge, []Task) {
+	g := &gauge{}
+	probes := []Task{}
+	for i := 0; i < N; i++ {
+		probes = append(probes, &probe{g: g, d: d})
+	}
+	return g, probes
+}
+
+//TestResizeGrow test workers are added beyond the initial DOP
+func TestResizeGrow(t *testing.T) {
+	g, probes := createProbes(60, 10*time.Millisecond)
+	h := Start(&Context{DOP: 2, FactoryFunc: factoryFuncOf(probes...)})
+	h.Resize(6)
+	if h.DOP() != 6 {
+		t.Errorf("expected DOP 6, actual %d", h.DOP())
+	}
+	report, err := h.Wait()
+	if err != nil || len(report.Results) != len(probes) {
+		t.Fatalf("expected %d tasks executed, actual %d err %v", len(probes), len(report.Results), err)
+	}
+	if g.max != 6 {
+		t.Errorf("expected at most 6 tasks running at the same time, actual %d", g.max)
+	}
+}
+
+//TestResizeShrink test workers quit until one is left
+func TestResizeShrink(t *testing.T) {
+	epoch := time.Unix(0, 0)
+	clock := NewFakeClock(epoch)
+	g, probes := createProbes(40, 10*time.Millisecond)
+	g.clock = clock
+	h := Start(&Context{DOP: 4, FactoryFunc: factoryFuncOf(probes...), Clock: clock})
+	clock.AfterFunc(15*time.Millisecond, func() {
+		h.Resize(0)
+		if h.DOP() != 1 {
+			t.Errorf("expected DOP 1, actual %d", h.DOP())
+		}
+	})
+	if err := clock.Run(h.done); err != nil {
+		t.Fatal(err)
+	}
+	report, err := h.Wait()
+	if err != nil || len(report.Results) != len(probes) {
+		t.Fatalf("expected %d tasks executed, actual %d err %v", len(probes), len(report.Results), err)
+	}
+	if g.max != 4 {
+		t.Errorf("expected 4 tasks running at the same time before shrinking, actual %d", g.max)
+	}
+	//tasks running at 15ms finish at 20ms, the 32 tasks left then run one after another
+	if d := clock.Now().Sub(epoch); d != 340*time.Millisecond {
+		t.Errorf("expected tasks to complete in 340ms, actual %v", d)
+	}
+	for _, p := range probes {
+		if p := p.(*probe); p.start.Sub(epoch) >= 20*time.Millisecond && p.active != 1 {
+			t.Errorf("expected a single task running after shrinking, actual %d", p.active)
+		}
+	}
+}
+
+//TestResizeAfterDone test Resize is harmless once all tasks are executed
+func TestResizeAfterDone(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	g, probes := createProbes(3, time.Millisecond)
+	g.clock = clock
+	h := Start(&Context{DOP: 1, FactoryFunc: factoryFuncOf(probes...), Clock: clock})
+	if err := clock.Run(h.done); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.Wait(); err != nil {
+		t.Fatalf("unexpected err %v", err)
+	}
+	h.Resize(8)
+	if _, err := h.Wait(); err != nil {
+		t.Errorf("unexpected err %v", err)
+	}
+}
+
+//checkGoroutines fails t if more than before goroutines are still running after a second
+func checkGoroutines(t *testing.T, before int) {
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			buf := make([]byte, 1<<16)
+			t.Errorf("expected %d goroutines, actual %d:\n%s", before, runtime.NumGoroutine(), buf[:runtime.Stack(buf, true)])
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+//TestNoLeak test no goroutines remain after a run stopped by an error or cancellation
+func TestNoLeak(t *testing.T) {
+	errFactory := errors.New("cannot make task")
+	clock := NewFakeClock(time.Unix(0, 0))
+	g, probes := createProbes(1000, time.Millisecond)
+	g.clock = clock
+	made := 0
+
+	tests := []struct {
+		name        string
+		c           Context
+		cancelAfter time.Duration //of clock
+		expectedErr error
+	}{
+		{"task error", Context{DOP: 4, FactoryFunc: factoryFuncSquares(1000, 10, errTest)}, 0, errTest},
+		{"factory error", Context{DOP: 4, FactoryFunc: func() (Task, error) {
+			if made == 10 {
+				return nil, errFactory
+			}
+			made++
+			return &square{n: made}, nil
+		}}, 0, errFactory},
+		{"cancellation", Context{DOP: 4, FactoryFunc: factoryFuncOf(probes...), Progress: &Progress{Logger: log.New(ioutil.Discard, "", 0)}}, 20 * time.Millisecond, context.Canceled},
+	}
+	for _, tt := range tests {
+		before := runtime.NumGoroutine()
+		var cancel context.CancelFunc
+		tt.c.Context, cancel = context.WithCancel(context.Background())
+		if tt.cancelAfter > 0 {
+			clock.AfterFunc(tt.cancelAfter, cancel)
+		}
+		if _, err := simulate(t, clock, &tt.c); err != tt.expectedErr {
+			t.Errorf("%s: expected err %v, actual err %v", tt.name, tt.expectedErr, err)
+		}
+		cancel()
+		checkGoroutines(t, before)
+	}
+}
+
+//timerWithTimeout is a timer with its own timeout
+type timerWithTimeout struct {
+	*timer
+	timeout time.Duration
+}
+
+//timerWithTimeout implements Timeouter
+func (tm timerWithTimeout) Timeout() time.Duration {
+	return tm.timeout
+}
+
+//TestTimeout test timers running longer than their timeout are reported as timed out
+func TestTimeout(t *testing.T) {
+	tests := []struct {
+		numTask          int
+		DOP              int
+		timeout          time.Duration
+		override         time.Duration //timeout of every other timer
+		expectedTimedOut int
+		expectedSec      float64
+	}{
+		{4, 2, 100 * time.Millisecond, 0, 4, 0.2},
+		{4, 4, 100 * time.Millisecond, 2 * time.Second, 2, 1},
+		{4, 4, 2 * time.Second, 0, 0, 1},
+	}
+	for _, tt := range tests {
+		clock := NewFakeClock(time.Unix(0, 0))
+		var tasks []Task
+		for i, tm := range createTimers(tt.numTask, clock) {
+			if i%2 == 1 && tt.override > 0 {
+				tasks = append(tasks, timerWithTimeout{tm, tt.override})
+				continue
+			}
+			tasks = append(tasks, tm)
+		}
+		c := Context{
+			DOP:         tt.DOP,
+			FactoryFunc: factoryFuncOf(tasks...),
+			ErrorPolicy: ContinueOnError,
+			Timeout:     tt.timeout,
+		}
+		start := clock.Now()
+		report, _ := simulate(t, clock, &c)
+		actualSec := clock.Now().Sub(start).Seconds()
+
+		timedOut := report.TimedOut()
+		if len(timedOut) != tt.expectedTimedOut || len(report.Failed()) != tt.expectedTimedOut {
+			t.Errorf("%+v: expected %d tasks timed out, actual %d", tt, tt.expectedTimedOut, len(timedOut))
+		}
+		for _, res := range timedOut {
+			if e, ok := res.Err.(*TimeoutError); !ok || e.Timeout != tt.timeout {
+				t.Errorf("%+v: expected TimeoutError after %v, actual %v", tt, tt.timeout, res.Err)
+			}
+		}
+		if actualSec != tt.expectedSec {
+			t.Errorf("%+v: expected tasks to complete in %v, actual %v", tt, tt.expectedSec, actualSec)
+		}
+	}
+}
+
+//hung is a plain Task which returns once released
+type hung struct {
+	calls   int32
+	release chan struct{}
+}
+
+func (h *hung) Exec(id WorkerID) error {
+	atomic.AddInt32(&h.calls, 1)
+	<-h.release
+	return nil
+}
+
+//TestTimeoutAbandoned test a Task which cannot be cancelled is abandoned once timed out, not retried
+func TestTimeoutAbandoned(t *testing.T) {
+	h := &hung{release: make(chan struct{})}
+	defer close(h.release)
+	report, err := DoReport(&Context{
+		DOP:         1,
+		FactoryFunc: factoryFuncOf(h),
+		Timeout:     10 * time.Millisecond,
+		Retry:       &RetryPolicy{MaxAttempts: 3},
+	})
+	if e, ok := err.(*TimeoutError); !ok || !e.Abandoned {
+		t.Fatalf("expected an abandoned TimeoutError, actual %v", err)
+	}
+	if res, calls := report.Results[0], atomic.LoadInt32(&h.calls); res.Status != TimedOut || res.Attempts != 1 || calls != 1 {
+		t.Errorf("expected the task executed once, actual %v after %d attempts, %d calls", res.Status, res.Attempts, calls)
+	}
 }